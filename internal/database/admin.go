@@ -0,0 +1,105 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"silent-notes/internal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+
+func (s *service) SetUserRole(userId primitive.ObjectID, role string) error {
+	update := bson.M{"$set": bson.M{"role": role}}
+	if role == models.RoleUser {
+		update = bson.M{"$unset": bson.M{"role": ""}}
+	}
+	result, err := UserCollection.UpdateByID(context.Background(), userId, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SearchUsers lists accounts newest first. search matches the start of the
+// username or email, role narrows to one role. Both may be empty.
+func (s *service) SearchUsers(search, role string, limit, skip int64) ([]models.UserModel, error) {
+	filter := bson.M{}
+	if search != "" {
+		prefix := primitive.Regex{Pattern: "^" + regexp.QuoteMeta(search), Options: "i"}
+		filter["$or"] = bson.A{bson.M{"username": prefix}, bson.M{"email": prefix}}
+	}
+	switch role {
+	case "":
+	case models.RoleUser:
+		filter["role"] = bson.M{"$in": bson.A{nil, models.RoleUser}}
+	default:
+		filter["role"] = role
+	}
+
+	cursor, err := UserCollection.Find(context.Background(), filter,
+		options.Find().
+			SetProjection(bson.M{"messages": 0}).
+			SetSort(bson.D{{Key: "_id", Value: -1}}).
+			SetLimit(limit).
+			SetSkip(skip))
+	if err != nil {
+		return nil, err
+	}
+
+	users := []models.UserModel{}
+	if err := cursor.All(context.Background(), &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// GetStats counts users, messages and moderation work. New accounts are
+// counted by the time in their ObjectID.
+func (s *service) GetStats(now time.Time) (*models.Stats, error) {
+	ctx := context.Background()
+	since := func(d time.Duration) primitive.ObjectID {
+		return primitive.NewObjectIDFromTimestamp(now.Add(-d))
+	}
+
+	var stats models.Stats
+	counts := []struct {
+		into  *int64
+		count func() (int64, error)
+	}{
+		{&stats.Users, func() (int64, error) { return UserCollection.EstimatedDocumentCount(ctx) }},
+		{&stats.VerifiedUsers, func() (int64, error) { return UserCollection.CountDocuments(ctx, bson.M{"is_verified": true}) }},
+		{&stats.NewUsersToday, func() (int64, error) {
+			return UserCollection.CountDocuments(ctx, bson.M{"_id": bson.M{"$gte": since(24 * time.Hour)}})
+		}},
+		{&stats.NewUsersThisWeek, func() (int64, error) {
+			return UserCollection.CountDocuments(ctx, bson.M{"_id": bson.M{"$gte": since(7 * 24 * time.Hour)}})
+		}},
+		{&stats.Messages, func() (int64, error) { return MessageCollection.EstimatedDocumentCount(ctx) }},
+		{&stats.MessagesToday, func() (int64, error) {
+			return MessageCollection.CountDocuments(ctx, bson.M{"_id": bson.M{"$gte": since(24 * time.Hour)}})
+		}},
+		{&stats.OpenReports, func() (int64, error) {
+			return ReportCollection.CountDocuments(ctx, bson.M{"status": models.ReportOpen})
+		}},
+		{&stats.ActiveSuspensions, func() (int64, error) {
+			return SuspensionCollection.CountDocuments(ctx, activeSuspension(bson.M{}))
+		}},
+	}
+	for _, c := range counts {
+		n, err := c.count()
+		if err != nil {
+			return nil, err
+		}
+		*c.into = n
+	}
+	return &stats, nil
+}
@@ -0,0 +1,48 @@
+package database
+
+import (
+	"context"
+	"regexp"
+	"silent-notes/internal/models"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AddAuditEvent appends to the audit trail, events are never changed.
+func (s *service) AddAuditEvent(event models.AuditEvent) error {
+	_, err := AuditCollection.InsertOne(context.Background(), event)
+	return err
+}
+
+// GetAuditEvents lists matching events, newest first. A zero limit returns
+// all of them.
+func (s *service) GetAuditEvents(filter models.AuditFilter, limit, skip int64) ([]models.AuditEvent, error) {
+	query := bson.M{}
+	if !filter.UserID.IsZero() {
+		if filter.WithTargeted {
+			query["$or"] = bson.A{bson.M{"user_id": filter.UserID}, bson.M{"target_id": filter.UserID}}
+		} else {
+			query["user_id"] = filter.UserID
+		}
+	}
+	if strings.HasSuffix(filter.Action, ".") {
+		query["action"] = primitive.Regex{Pattern: "^" + regexp.QuoteMeta(filter.Action)}
+	} else if filter.Action != "" {
+		query["action"] = filter.Action
+	}
+
+	cursor, err := AuditCollection.Find(context.Background(), query,
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(limit).SetSkip(skip))
+	if err != nil {
+		return nil, err
+	}
+
+	events := []models.AuditEvent{}
+	if err := cursor.All(context.Background(), &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
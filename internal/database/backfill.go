@@ -0,0 +1,25 @@
+package database
+
+import (
+	"context"
+	"silent-notes/internal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// backfillMessageDates repairs messages an earlier run of migrateEmbeddedMessages
+// copied with a zero send time. That run also undid their read state by marking
+// them unread, those untouched since are put back to read.
+func backfillMessageDates(ctx context.Context) error {
+	_, err := MessageCollection.UpdateMany(ctx,
+		bson.M{"created_at": bson.M{"$lt": time.Unix(0, 0)}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"created_at": bson.M{"$toDate": "$_id"},
+			"state": bson.M{"$cond": bson.A{
+				bson.M{"$eq": bson.A{"$state", models.MessageUnread}}, models.MessageRead, "$state",
+			}},
+		}}}})
+	return err
+}
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"silent-notes/internal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrBlockNotFound = errors.New("block not found")
+
+// BlockSender stores the block, blocking the same sender twice returns the
+// block that already exists.
+func (s *service) BlockSender(block models.SenderBlock) (*models.SenderBlock, error) {
+	var stored models.SenderBlock
+	err := BlockCollection.FindOneAndUpdate(context.Background(),
+		bson.M{"user_id": block.UserID, "fingerprint": block.Fingerprint},
+		bson.M{"$setOnInsert": block},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&stored)
+	if err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+func (s *service) GetSenderBlocks(userId primitive.ObjectID) ([]models.SenderBlock, error) {
+	cursor, err := BlockCollection.Find(context.Background(),
+		bson.M{"user_id": userId, "expires_at": bson.M{"$gt": time.Now()}},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := []models.SenderBlock{}
+	if err := cursor.All(context.Background(), &blocks); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+func (s *service) DeleteSenderBlock(userId, blockId primitive.ObjectID) error {
+	result, err := BlockCollection.DeleteOne(context.Background(), bson.M{"_id": blockId, "user_id": userId})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrBlockNotFound
+	}
+	return nil
+}
+
+// IsSenderBlocked reports whether any of the sender's fingerprints is blocked
+// by the user. Expired blocks are skipped as the TTL monitor only runs once a
+// minute.
+func (s *service) IsSenderBlocked(userId primitive.ObjectID, fingerprints []string) (bool, error) {
+	count, err := BlockCollection.CountDocuments(context.Background(),
+		bson.M{"user_id": userId, "fingerprint": bson.M{"$in": fingerprints}, "expires_at": bson.M{"$gt": time.Now()}},
+		options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// ClearFingerprints removes fingerprints from messages received before the
+// cutoff, they can no longer match a sender so there is no reason to keep them.
+func (s *service) ClearFingerprints(before time.Time) (int64, error) {
+	result, err := MessageCollection.UpdateMany(context.Background(),
+		bson.M{"fingerprint": bson.M{"$exists": true}, "created_at": bson.M{"$lt": before}},
+		bson.M{"$unset": bson.M{"fingerprint": ""}})
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"context"
+	"silent-notes/internal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (s *service) CreateDataExport(export models.DataExport) error {
+	_, err := DataExportCollection.InsertOne(context.Background(), export)
+	return err
+}
+
+func (s *service) GetDataExport(exportId, userId primitive.ObjectID) *models.DataExport {
+	var export models.DataExport
+	err := DataExportCollection.FindOne(context.Background(), bson.M{"_id": exportId, "user_id": userId}).Decode(&export)
+	if err != nil {
+		return nil
+	}
+	return &export
+}
+
+// GetOpenDataExport returns an export for the user that is still queued or
+// being built, so repeated requests don't pile up work.
+func (s *service) GetOpenDataExport(userId primitive.ObjectID) *models.DataExport {
+	var export models.DataExport
+	filter := bson.M{"user_id": userId, "status": bson.M{"$in": bson.A{models.ExportPending, models.ExportProcessing}}}
+	err := DataExportCollection.FindOne(context.Background(), filter).Decode(&export)
+	if err != nil {
+		return nil
+	}
+	return &export
+}
+
+// ClaimDataExport marks the oldest pending export as processing and returns
+// it, or nil when the queue is empty. Exports claimed before staleBefore are
+// taken again, the process building them died before finishing.
+func (s *service) ClaimDataExport(staleBefore time.Time) (*models.DataExport, error) {
+	var export models.DataExport
+	err := DataExportCollection.FindOneAndUpdate(context.Background(),
+		bson.M{"$or": bson.A{
+			bson.M{"status": models.ExportPending},
+			bson.M{"status": models.ExportProcessing, "$or": bson.A{
+				bson.M{"claimed_at": bson.M{"$lt": staleBefore}},
+				bson.M{"claimed_at": nil},
+			}},
+		}},
+		bson.M{"$set": bson.M{"status": models.ExportProcessing, "claimed_at": time.Now()}, "$inc": bson.M{"attempts": 1}},
+		options.FindOneAndUpdate().SetSort(bson.M{"created_at": 1}).SetReturnDocument(options.After),
+	).Decode(&export)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+func (s *service) CompleteDataExport(exportId primitive.ObjectID, file string, expiresAt time.Time) error {
+	_, err := DataExportCollection.UpdateByID(context.Background(), exportId, bson.M{
+		"$set": bson.M{
+			"status":       models.ExportReady,
+			"file":         file,
+			"completed_at": time.Now(),
+			"expires_at":   expiresAt,
+		},
+	})
+	return err
+}
+
+func (s *service) FailDataExport(exportId primitive.ObjectID, reason string) error {
+	_, err := DataExportCollection.UpdateByID(context.Background(), exportId, bson.M{
+		"$set": bson.M{
+			"status":       models.ExportFailed,
+			"error":        reason,
+			"completed_at": time.Now(),
+		},
+	})
+	return err
+}
+
+func (s *service) GetExpiredDataExports(before time.Time) ([]models.DataExport, error) {
+	cursor, err := DataExportCollection.Find(context.Background(), bson.M{"expires_at": bson.M{"$lte": before}})
+	if err != nil {
+		return nil, err
+	}
+	var exports []models.DataExport
+	if err := cursor.All(context.Background(), &exports); err != nil {
+		return nil, err
+	}
+	return exports, nil
+}
+
+func (s *service) DeleteDataExport(exportId primitive.ObjectID) error {
+	_, err := DataExportCollection.DeleteOne(context.Background(), bson.M{"_id": exportId})
+	return err
+}
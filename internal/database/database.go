@@ -9,6 +9,7 @@ import (
 	"time"
 
 	_ "github.com/joho/godotenv/autoload"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -22,9 +23,97 @@ type Service interface {
 	GetUser(identifier, projection string) *models.UserModel
 	ReVerifyCode(userId primitive.ObjectID, verifyCode int, verifyCodeExpiry time.Time) (interface{}, error)
 	ToggleAcceptMessages(isAcceptingMessages bool, userId primitive.ObjectID) bool
-	AddMessage(username string, message models.Message) error
-	GetMessages(userId primitive.ObjectID) ([]models.Message, error)
+	AddMessage(userId primitive.ObjectID, message models.Message) error
+	AddDroppedMessage(userId primitive.ObjectID, message models.Message) error
+	GetMessages(userId primitive.ObjectID, filter models.MessageFilter) ([]models.Message, error)
+	GetMessage(userId, messageId primitive.ObjectID) (*models.Message, error)
+	SetMessageReply(userId, messageId primitive.ObjectID, reply *models.MessageReply) error
+	GetMessageBySenderToken(tokenHash string) (*models.Message, error)
+	AddFollowUp(tokenHash string, followUp models.MessageReply) error
+	ClearFingerprints(before time.Time) (int64, error)
+	BlockSender(block models.SenderBlock) (*models.SenderBlock, error)
+	GetSenderBlocks(userId primitive.ObjectID) ([]models.SenderBlock, error)
+	DeleteSenderBlock(userId, blockId primitive.ObjectID) error
+	IsSenderBlocked(userId primitive.ObjectID, fingerprints []string) (bool, error)
+	CreateReport(report models.Report) error
+	GetReports(status, category string, limit, skip int64) ([]models.Report, error)
+	GetReport(reportId primitive.ObjectID) (*models.Report, error)
+	ResolveReport(reportId primitive.ObjectID, status string, moderatorId primitive.ObjectID, note string) error
+	RemoveMessage(messageId primitive.ObjectID) error
+	AddSuspension(suspension models.Suspension) error
+	GetSuspensions() ([]models.Suspension, error)
+	DeleteSuspension(suspensionId primitive.ObjectID) (*models.Suspension, error)
+	IsSenderSuspended(fingerprints []string) (bool, error)
+	IsUserSuspended(userId primitive.ObjectID) (bool, error)
+	LogModeration(action models.ModerationAction) error
+	GetModerationLog(limit, skip int64) ([]models.ModerationAction, error)
+	AddAuditEvent(event models.AuditEvent) error
+	GetAuditEvents(filter models.AuditFilter, limit, skip int64) ([]models.AuditEvent, error)
+	CreateSession(session models.Session) error
+	GetSession(userId, sessionId primitive.ObjectID) (*models.Session, error)
+	TouchSession(sessionId primitive.ObjectID, at time.Time, ip string) error
+	GetSessions(userId primitive.ObjectID) ([]models.Session, error)
+	DeleteSession(userId, sessionId primitive.ObjectID) error
+	DeleteSessions(userId, keep primitive.ObjectID) (int64, error)
+	SetUserRole(userId primitive.ObjectID, role string) error
+	SearchUsers(search, role string, limit, skip int64) ([]models.UserModel, error)
+	GetStats(now time.Time) (*models.Stats, error)
+	StreamMessages(ctx context.Context, userId primitive.ObjectID, filter models.MessageFilter) (*mongo.Cursor, error)
+	MessageIDs(userId primitive.ObjectID, filter models.MessageFilter, limit int64) ([]primitive.ObjectID, error)
+	ApplyMessageAction(userId primitive.ObjectID, filter models.MessageFilter, action string, readExpiry *time.Time, limit int64) ([]primitive.ObjectID, int64, error)
+	SearchMessages(userId primitive.ObjectID, search string, filter models.MessageFilter, limit, skip int64) ([]models.MessageSearchResult, error)
 	DeleteMessage(userId, messageId primitive.ObjectID) error
+	GetTrashedMessages(userId primitive.ObjectID) ([]models.Message, error)
+	RestoreMessage(userId, messageId primitive.ObjectID) error
+	EmptyTrash(userId primitive.ObjectID) ([]primitive.ObjectID, error)
+	PurgeTrashedMessages(before time.Time) ([]models.Message, error)
+	CountExpiringMessages(userId primitive.ObjectID, policy models.RetentionPolicy, readTTL time.Duration, now time.Time) (int64, error)
+	ApplyRetentionPolicy(userId primitive.ObjectID, policy models.RetentionPolicy, readTTL time.Duration) error
+	SetRetentionPolicy(userId primitive.ObjectID, policy models.RetentionPolicy) error
+	UpdateProfile(userId primitive.ObjectID, profile models.UserProfile) error
+	SetAvatar(userId primitive.ObjectID, avatar string, urls map[string]string, keys []string) error
+	SetAcceptSchedule(userId primitive.ObjectID, schedule *models.AcceptSchedule) error
+	CountScheduledMessage(userId primitive.ObjectID, maxMessages int) (bool, error)
+	ReleaseScheduledMessage(userId primitive.ObjectID) error
+	AddPrompt(userId primitive.ObjectID, prompt models.Prompt) error
+	UpdatePrompt(userId primitive.ObjectID, prompt models.Prompt) error
+	DeletePrompt(userId, promptId primitive.ObjectID) error
+	CountPromptMessage(userId primitive.ObjectID, prompt models.Prompt) (bool, error)
+	ReleasePromptMessage(userId, promptId primitive.ObjectID) error
+	GetUserByID(userId primitive.ObjectID) *models.UserModel
+	SetPendingMFASecret(userId primitive.ObjectID, secret string) error
+	EnableMFA(userId primitive.ObjectID, secret string, lastStep int64, recoveryCodes []string) error
+	DisableMFA(userId primitive.ObjectID) error
+	SetRecoveryCodes(userId primitive.ObjectID, recoveryCodes []string) error
+	ConsumeTOTPStep(userId primitive.ObjectID, step int64) bool
+	ConsumeRecoveryCode(userId primitive.ObjectID, codeHash string) bool
+	GetAuthThrottle(key string) *models.AuthThrottle
+	RecordAuthFailure(key string, window time.Duration) (*models.AuthThrottle, error)
+	LockAuthKey(key string, until time.Time) error
+	ClearAuthFailures(key string) error
+	IncrementVerifyAttempts(userId primitive.ObjectID) (int, error)
+	InvalidateVerifyCode(userId primitive.ObjectID) error
+	SetMagicLinkID(userId primitive.ObjectID, jti string) error
+	ConsumeMagicLink(userId primitive.ObjectID, jti string) bool
+	GetUserByIdentity(provider, subject string) *models.UserModel
+	LinkIdentity(userId primitive.ObjectID, identity models.ExternalIdentity) error
+	UpdatePassword(userId primitive.ObjectID, hashedPassword string) error
+	SetPendingEmail(userId primitive.ObjectID, email string, verifyCode int, verifyCodeExpiry time.Time) error
+	ConfirmPendingEmail(userId primitive.ObjectID) error
+	UsernameTaken(username string, exceptUserId primitive.ObjectID) bool
+	ChangeUsername(userId primitive.ObjectID, oldUsername, newUsername string, aliasUntil time.Time) error
+	ScheduleAccountDeletion(userId primitive.ObjectID, at time.Time) error
+	CancelAccountDeletion(userId primitive.ObjectID) error
+	GetUsersDueForDeletion(before time.Time) ([]models.UserModel, error)
+	PurgeUser(userId primitive.ObjectID) error
+	CreateDataExport(export models.DataExport) error
+	GetDataExport(exportId, userId primitive.ObjectID) *models.DataExport
+	GetOpenDataExport(userId primitive.ObjectID) *models.DataExport
+	ClaimDataExport(staleBefore time.Time) (*models.DataExport, error)
+	CompleteDataExport(exportId primitive.ObjectID, file string, expiresAt time.Time) error
+	FailDataExport(exportId primitive.ObjectID, reason string) error
+	GetExpiredDataExports(before time.Time) ([]models.DataExport, error)
+	DeleteDataExport(exportId primitive.ObjectID) error
 }
 
 type service struct {
@@ -32,8 +121,17 @@ type service struct {
 }
 
 var (
-	UserCollection    *mongo.Collection
-	MessageCollection *mongo.Collection
+	UserCollection          *mongo.Collection
+	MessageCollection       *mongo.Collection
+	DroppedCollection       *mongo.Collection
+	AuthThrottleCollection  *mongo.Collection
+	DataExportCollection    *mongo.Collection
+	BlockCollection         *mongo.Collection
+	ReportCollection        *mongo.Collection
+	SuspensionCollection    *mongo.Collection
+	ModerationLogCollection *mongo.Collection
+	AuditCollection         *mongo.Collection
+	SessionCollection       *mongo.Collection
 )
 
 var (
@@ -52,12 +150,239 @@ func New() Service {
 	}
 	UserCollection = client.Database(database).Collection(userColl)
 	MessageCollection = client.Database(database).Collection(messageColl)
+	DroppedCollection = client.Database(database).Collection("dropped_messages")
+	AuthThrottleCollection = client.Database(database).Collection("auth_throttles")
+	DataExportCollection = client.Database(database).Collection("data_exports")
+	BlockCollection = client.Database(database).Collection("sender_blocks")
+	ReportCollection = client.Database(database).Collection("reports")
+	SuspensionCollection = client.Database(database).Collection("suspensions")
+	ModerationLogCollection = client.Database(database).Collection("moderation_log")
+	AuditCollection = client.Database(database).Collection("audit_events")
+	SessionCollection = client.Database(database).Collection("sessions")
+
+	if err := ensureIndexes(); err != nil {
+		log.Fatal(err)
+	}
+	if err := migrateEmbeddedMessages(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	if err := backfillMessageDates(context.Background()); err != nil {
+		log.Fatal(err)
+	}
 
 	return &service{
 		db: client,
 	}
 }
 
+// auditRetention is how long audit events are kept. Expiring them is a
+// deliberate exception to the trail being append-only, as is PurgeUser taking
+// a deleted account's own non-admin events with it. The TTL index has to be
+// changed with collMod if this ever changes.
+const auditRetention = 365 * 24 * time.Hour
+
+// droppedMessageRetention is how long a blocked sender's token keeps showing
+// their message when the owner's retention policy does not end it sooner.
+const droppedMessageRetention = 90 * 24 * time.Hour
+
+func ensureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// throttle entries are only useful for a day after the last failure
+	_, err := AuthThrottleCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "last_failure_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32((24 * time.Hour).Seconds())),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = UserCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "identities.provider", Value: 1}, {Key: "identities.subject", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// the checks before sign-up and renames race, this is what keeps names unique
+	_, err = UserCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "username", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = MessageCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = MessageCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "trashed_at", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = MessageCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "sender_token_hash", Value: 1}},
+		Options: options.Index().SetUnique(true).SetSparse(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = MessageCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "fingerprint", Value: 1}, {Key: "created_at", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = BlockCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "fingerprint", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	// a block is useless once its fingerprint's salt has rotated out
+	_, err = BlockCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return err
+	}
+
+	// one open report per message, resolved ones stay as history
+	_, err = ReportCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "message_id", Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"status": models.ReportOpen}),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = ReportCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = SuspensionCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = SuspensionCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "kind", Value: 1}, {Key: "fingerprint", Value: 1}, {Key: "user_id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = AuditCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = AuditCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "target_id", Value: 1}, {Key: "created_at", Value: -1}},
+		Options: options.Index().SetSparse(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	// the trail holds addresses and browsers, so it is not kept forever
+	_, err = AuditCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(auditRetention.Seconds())),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = SessionCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "last_seen_at", Value: -1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// sessions go away together with their token
+	_, err = SessionCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return err
+	}
+
+	// expires_at is set by the owner's retention policy
+	_, err = MessageCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return err
+	}
+
+	// messages from blocked senders never reach the inbox, they are only kept
+	// so the sender's token answers like it would for a delivered message
+	_, err = DroppedCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "sender_token_hash", Value: 1}},
+		Options: options.Index().SetUnique(true).SetSparse(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = DroppedCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = DroppedCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(droppedMessageRetention.Seconds())),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = DroppedCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return err
+	}
+
+	// prefixed with user_id so a search only walks the owner's part of the index
+	_, err = MessageCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "content", Value: "text"}},
+		Options: options.Index().SetName("message_content_text").SetDefaultLanguage("none"),
+	})
+	return err
+}
+
 func (s *service) Health() map[string]string {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
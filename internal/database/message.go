@@ -0,0 +1,455 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"silent-notes/internal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrMessageNotFound = errors.New("message not found")
+	ErrFollowUpExists  = errors.New("follow-up already sent")
+)
+
+func (s *service) AddMessage(userId primitive.ObjectID, message models.Message) error {
+	message.UserID = userId
+	if message.State == "" {
+		message.State = models.MessageUnread
+	}
+	_, err := MessageCollection.InsertOne(context.Background(), message)
+	return err
+}
+
+func (s *service) GetMessages(userId primitive.ObjectID, filter models.MessageFilter) ([]models.Message, error) {
+	cursor, err := MessageCollection.Find(context.Background(), messageQuery(userId, filter),
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	var userMessages []models.Message
+	if err := cursor.All(context.Background(), &userMessages); err != nil {
+		return nil, err
+	}
+
+	if len(userMessages) == 0 {
+		return nil, nil
+	}
+	return userMessages, nil
+}
+
+// GetTrashedMessages lists the user's trash, most recently deleted first.
+func (s *service) GetTrashedMessages(userId primitive.ObjectID) ([]models.Message, error) {
+	cursor, err := MessageCollection.Find(context.Background(), messageQuery(userId, models.MessageFilter{Trashed: true}),
+		options.Find().SetSort(bson.D{{Key: "trashed_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []models.Message{}
+	if err := cursor.All(context.Background(), &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// StreamMessages returns a cursor over the user's messages oldest first so
+// large inboxes can be written out without loading them into memory.
+func (s *service) StreamMessages(ctx context.Context, userId primitive.ObjectID, filter models.MessageFilter) (*mongo.Cursor, error) {
+	return MessageCollection.Find(ctx, messageQuery(userId, filter),
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}).SetBatchSize(500))
+}
+
+// SearchMessages runs a text search over the user's messages, best matches
+// first. Quoted phrases in search must appear as written.
+func (s *service) SearchMessages(userId primitive.ObjectID, search string, filter models.MessageFilter, limit, skip int64) ([]models.MessageSearchResult, error) {
+	query := messageQuery(userId, filter)
+	query["$text"] = bson.M{"$search": search}
+
+	score := bson.M{"$meta": "textScore"}
+	cursor, err := MessageCollection.Find(context.Background(), query,
+		options.Find().
+			SetProjection(bson.M{"score": score}).
+			SetSort(bson.D{{Key: "score", Value: score}, {Key: "created_at", Value: -1}}).
+			SetLimit(limit).
+			SetSkip(skip))
+	if err != nil {
+		return nil, err
+	}
+
+	results := []models.MessageSearchResult{}
+	if err := cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// GetMessage returns one of the user's messages, trashed ones count as gone.
+func (s *service) GetMessage(userId, messageId primitive.ObjectID) (*models.Message, error) {
+	var message models.Message
+	err := MessageCollection.FindOne(context.Background(), bson.M{"_id": messageId, "user_id": userId, "trashed_at": nil}).Decode(&message)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// SetMessageReply sets the owner's answer to a message, nil removes it.
+func (s *service) SetMessageReply(userId, messageId primitive.ObjectID, reply *models.MessageReply) error {
+	update := bson.M{"$set": bson.M{"reply": reply}}
+	if reply == nil {
+		update = bson.M{"$unset": bson.M{"reply": ""}}
+	}
+	result, err := MessageCollection.UpdateOne(context.Background(),
+		bson.M{"_id": messageId, "user_id": userId, "trashed_at": nil}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrMessageNotFound
+	}
+	return nil
+}
+
+// AddDroppedMessage keeps a message from a blocked sender out of the inbox.
+func (s *service) AddDroppedMessage(userId primitive.ObjectID, message models.Message) error {
+	message.UserID = userId
+	_, err := DroppedCollection.InsertOne(context.Background(), message)
+	return err
+}
+
+// GetMessageBySenderToken finds the message a sender token was issued for,
+// dropped messages included so blocked senders cannot tell.
+func (s *service) GetMessageBySenderToken(tokenHash string) (*models.Message, error) {
+	var message models.Message
+	err := MessageCollection.FindOne(context.Background(), bson.M{"sender_token_hash": tokenHash, "trashed_at": nil}).Decode(&message)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = DroppedCollection.FindOne(context.Background(), bson.M{"sender_token_hash": tokenHash}).Decode(&message)
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// AddFollowUp stores the sender's one follow-up to an answered message and
+// marks it unread again so the owner notices.
+func (s *service) AddFollowUp(tokenHash string, followUp models.MessageReply) error {
+	result, err := MessageCollection.UpdateOne(context.Background(),
+		bson.M{"sender_token_hash": tokenHash, "trashed_at": nil, "reply": bson.M{"$ne": nil}, "follow_up": nil},
+		bson.M{"$set": bson.M{"follow_up": followUp, "state": models.MessageUnread}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrFollowUpExists
+	}
+	return nil
+}
+
+// DeleteMessage moves a message to the trash, it is purged for good once the
+// trash retention is over.
+func (s *service) DeleteMessage(userId, messageId primitive.ObjectID) error {
+	result, err := MessageCollection.UpdateOne(context.Background(),
+		bson.M{"_id": messageId, "user_id": userId, "trashed_at": nil},
+		bson.M{"$set": bson.M{"trashed_at": time.Now()}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrMessageNotFound
+	}
+	return nil
+}
+
+func (s *service) RestoreMessage(userId, messageId primitive.ObjectID) error {
+	result, err := MessageCollection.UpdateOne(context.Background(),
+		bson.M{"_id": messageId, "user_id": userId, "trashed_at": bson.M{"$ne": nil}},
+		bson.M{"$unset": bson.M{"trashed_at": ""}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrMessageNotFound
+	}
+	return nil
+}
+
+// EmptyTrash permanently deletes everything in the user's trash and returns
+// the ids of the deleted messages.
+func (s *service) EmptyTrash(userId primitive.ObjectID) ([]primitive.ObjectID, error) {
+	filter := models.MessageFilter{Trashed: true}
+	ids, err := s.MessageIDs(userId, filter, 0)
+	if err != nil || len(ids) == 0 {
+		return ids, err
+	}
+	filter.IDs = ids
+	_, err = MessageCollection.DeleteMany(context.Background(), messageQuery(userId, filter))
+	return ids, err
+}
+
+// PurgeTrashedMessages permanently deletes messages trashed before the cutoff.
+// The returned messages only carry their id and owner.
+func (s *service) PurgeTrashedMessages(before time.Time) ([]models.Message, error) {
+	ctx := context.Background()
+	filter := bson.M{"trashed_at": bson.M{"$lt": before}}
+	cursor, err := MessageCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1, "user_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var purged []models.Message
+	if err := cursor.All(ctx, &purged); err != nil {
+		return nil, err
+	}
+	if len(purged) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(purged))
+	for _, message := range purged {
+		ids = append(ids, message.ID)
+	}
+	filter["_id"] = bson.M{"$in": ids}
+	_, err = MessageCollection.DeleteMany(ctx, filter)
+	return purged, err
+}
+
+// MessageIDs returns up to limit ids of the user's messages matching filter,
+// oldest first.
+func (s *service) MessageIDs(userId primitive.ObjectID, filter models.MessageFilter, limit int64) ([]primitive.ObjectID, error) {
+	cursor, err := MessageCollection.Find(context.Background(), messageQuery(userId, filter),
+		options.Find().SetProjection(bson.M{"_id": 1}).SetSort(bson.D{{Key: "created_at", Value: 1}}).SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+
+	var found []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(context.Background(), &found); err != nil {
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(found))
+	for _, message := range found {
+		ids = append(ids, message.ID)
+	}
+	return ids, nil
+}
+
+// ApplyMessageAction runs a bulk action over the user's messages matching
+// filter as a single write. Only messages the action actually changes are
+// written, each stamped with the operation so up to limit of their ids can be
+// read back before the stamp is removed again. It returns those ids and how many messages were changed in all.
+// readExpiry brings the expiry of messages marked read forward, for inboxes
+// that delete messages after reading.
+func (s *service) ApplyMessageAction(userId primitive.ObjectID, filter models.MessageFilter, action string, readExpiry *time.Time, limit int64) ([]primitive.ObjectID, int64, error) {
+	ctx := context.Background()
+	query := messageQuery(userId, filter)
+	operation := primitive.NewObjectID()
+	set := bson.M{"bulk_operation": operation}
+
+	switch action {
+	case models.MessageActionDelete:
+		set["trashed_at"] = time.Now()
+	case models.MessageActionArchive:
+		if filter.State == "" {
+			query["state"] = bson.M{"$ne": models.MessageArchived}
+		}
+		set["state"] = models.MessageArchived
+	case models.MessageActionMarkRead:
+		// archived messages stay archived, reading them does not bring them back
+		query["state"] = models.MessageUnread
+		set["state"] = models.MessageRead
+		set["read_at"] = time.Now()
+		if readExpiry != nil {
+			set["expires_at"] = bson.M{"$min": bson.A{bson.M{"$ifNull": bson.A{"$expires_at", *readExpiry}}, *readExpiry}}
+		}
+	default:
+		return nil, 0, fmt.Errorf("unknown message action %q", action)
+	}
+
+	result, err := MessageCollection.UpdateMany(ctx, query, mongo.Pipeline{{{Key: "$set", Value: set}}})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ids := []primitive.ObjectID{}
+	if result.ModifiedCount == 0 {
+		return ids, 0, nil
+	}
+	// the stamp is only needed to read the ids back, it must not stay on the
+	// messages or show up in exports
+	defer func() {
+		_, err := MessageCollection.UpdateMany(ctx, bson.M{"user_id": userId, "bulk_operation": operation},
+			bson.M{"$unset": bson.M{"bulk_operation": ""}})
+		if err != nil {
+			log.Printf("error clearing bulk operation %s: %v", operation.Hex(), err)
+		}
+	}()
+	cursor, err := MessageCollection.Find(ctx, bson.M{"user_id": userId, "bulk_operation": operation},
+		options.Find().SetProjection(bson.M{"_id": 1}).SetSort(bson.D{{Key: "created_at", Value: 1}}).SetLimit(limit))
+	if err != nil {
+		return nil, 0, err
+	}
+	var changed []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &changed); err != nil {
+		return nil, 0, err
+	}
+	for _, message := range changed {
+		ids = append(ids, message.ID)
+	}
+	return ids, result.ModifiedCount, nil
+}
+
+// CountExpiringMessages counts the messages that policy would delete within
+// readTTL of now and that are not already due by then, so the user can be
+// warned before a new policy reaches back into their inbox.
+func (s *service) CountExpiringMessages(userId primitive.ObjectID, policy models.RetentionPolicy, readTTL time.Duration, now time.Time) (int64, error) {
+	soon := now.Add(readTTL)
+	var expiring []bson.M
+	if policy.AfterDays > 0 {
+		expiring = append(expiring, bson.M{"created_at": bson.M{"$lt": soon.AddDate(0, 0, -policy.AfterDays)}})
+	}
+	if policy.AfterRead {
+		expiring = append(expiring, bson.M{"read_at": bson.M{"$ne": nil}})
+	}
+	if len(expiring) == 0 {
+		return 0, nil
+	}
+
+	return MessageCollection.CountDocuments(context.Background(), bson.M{
+		"user_id": userId,
+		"$and": []bson.M{
+			{"$or": expiring},
+			{"$or": []bson.M{{"expires_at": nil}, {"expires_at": bson.M{"$gt": soon}}}},
+		},
+	})
+}
+
+// ApplyRetentionPolicy recomputes the expiry of every message of the user
+// after the policy changed. Messages already older than the new limit expire
+// right away, the TTL index removes them within a minute.
+func (s *service) ApplyRetentionPolicy(userId primitive.ObjectID, policy models.RetentionPolicy, readTTL time.Duration) error {
+	var update interface{}
+	if policy.AfterDays > 0 {
+		window := int64(policy.AfterDays) * int64((24 * time.Hour).Milliseconds())
+		update = mongo.Pipeline{{{Key: "$set", Value: bson.M{"expires_at": bson.M{"$add": bson.A{"$created_at", window}}}}}}
+	} else {
+		update = bson.M{"$unset": bson.M{"expires_at": ""}}
+	}
+	if _, err := MessageCollection.UpdateMany(context.Background(), bson.M{"user_id": userId}, update); err != nil {
+		return err
+	}
+
+	if !policy.AfterRead {
+		return nil
+	}
+	// counted from when each message was read, saving the policy again must
+	// not give messages read long ago a fresh window
+	at := bson.M{"$add": bson.A{"$read_at", readTTL.Milliseconds()}}
+	_, err := MessageCollection.UpdateMany(context.Background(),
+		bson.M{"user_id": userId, "read_at": bson.M{"$ne": nil}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"expires_at": bson.M{"$min": bson.A{bson.M{"$ifNull": bson.A{"$expires_at", at}}, at}},
+		}}}})
+	return err
+}
+
+func messageQuery(userId primitive.ObjectID, filter models.MessageFilter) bson.M {
+	query := bson.M{"user_id": userId}
+
+	if filter.Trashed {
+		query["trashed_at"] = bson.M{"$ne": nil}
+	} else {
+		query["trashed_at"] = nil
+	}
+	if filter.IDs != nil {
+		query["_id"] = bson.M{"$in": filter.IDs}
+	}
+	if !filter.PromptID.IsZero() {
+		query["prompt_id"] = filter.PromptID
+	}
+
+	createdAt := bson.M{}
+	if !filter.From.IsZero() {
+		createdAt["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		createdAt["$lte"] = filter.To
+	}
+	if !filter.Before.IsZero() {
+		createdAt["$lt"] = filter.Before
+	}
+	if len(createdAt) > 0 {
+		query["created_at"] = createdAt
+	}
+
+	if filter.State != "" {
+		query["state"] = filter.State
+	}
+	if filter.Contains != "" {
+		query["content"] = bson.M{"$regex": regexp.QuoteMeta(filter.Contains), "$options": "i"}
+	}
+	return query
+}
+
+// migrateEmbeddedMessages moves messages that older versions stored inside the
+// user document into the messages collection. Those versions saved no send
+// time, so it is taken from the id, and had no read state, so messages that
+// were already in the inbox count as read.
+func migrateEmbeddedMessages(ctx context.Context) error {
+	cursor, err := UserCollection.Find(ctx, bson.M{"messages.0": bson.M{"$exists": true}},
+		options.Find().SetProjection(bson.M{"_id": 1, "messages": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var user models.UserModel
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+
+		docs := make([]interface{}, 0, len(user.Messages))
+		for _, message := range user.Messages {
+			message.UserID = user.ID
+			if message.CreatedAt.IsZero() {
+				message.CreatedAt = message.ID.Timestamp()
+			}
+			if message.State == "" {
+				message.State = models.MessageRead
+			}
+			docs = append(docs, message)
+		}
+
+		// unordered so messages copied by an interrupted earlier run are skipped
+		_, err := MessageCollection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+		if _, err := UserCollection.UpdateByID(ctx, user.ID, bson.M{"$unset": bson.M{"messages": ""}}); err != nil {
+			return err
+		}
+		log.Printf("migrated %d messages of user %s", len(docs), user.ID.Hex())
+	}
+	return cursor.Err()
+}
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (s *service) SetPendingMFASecret(userId primitive.ObjectID, secret string) error {
+	updateFilter := bson.M{
+		"$set": bson.M{"mfa_pending_secret": secret},
+	}
+	result, err := UserCollection.UpdateByID(context.Background(), userId, updateFilter)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+func (s *service) EnableMFA(userId primitive.ObjectID, secret string, lastStep int64, recoveryCodes []string) error {
+	filter := bson.M{"_id": userId, "mfa_pending_secret": secret}
+	updateFilter := bson.M{
+		"$set": bson.M{
+			"mfa_enabled":    true,
+			"mfa_secret":     secret,
+			"mfa_last_step":  lastStep,
+			"recovery_codes": recoveryCodes,
+		},
+		"$unset": bson.M{"mfa_pending_secret": ""},
+	}
+	result, err := UserCollection.UpdateOne(context.Background(), filter, updateFilter)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("no pending mfa enrollment")
+	}
+	return nil
+}
+
+func (s *service) DisableMFA(userId primitive.ObjectID) error {
+	updateFilter := bson.M{
+		"$unset": bson.M{
+			"mfa_enabled":        "",
+			"mfa_secret":         "",
+			"mfa_pending_secret": "",
+			"mfa_last_step":      "",
+			"recovery_codes":     "",
+		},
+	}
+	result, err := UserCollection.UpdateByID(context.Background(), userId, updateFilter)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+func (s *service) SetRecoveryCodes(userId primitive.ObjectID, recoveryCodes []string) error {
+	updateFilter := bson.M{
+		"$set": bson.M{"recovery_codes": recoveryCodes},
+	}
+	result, err := UserCollection.UpdateByID(context.Background(), userId, updateFilter)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+// ConsumeTOTPStep records step as used, it fails if an equal or later step
+// was already accepted so the same code can't be replayed.
+func (s *service) ConsumeTOTPStep(userId primitive.ObjectID, step int64) bool {
+	filter := bson.M{
+		"_id": userId,
+		"$or": []bson.M{
+			{"mfa_last_step": bson.M{"$lt": step}},
+			{"mfa_last_step": bson.M{"$exists": false}},
+		},
+	}
+	result, err := UserCollection.UpdateOne(context.Background(), filter, bson.M{"$set": bson.M{"mfa_last_step": step}})
+	if err != nil {
+		return false
+	}
+	return result.ModifiedCount == 1
+}
+
+// ConsumeRecoveryCode removes the hashed code, it reports false if the code
+// was never issued or has already been used.
+func (s *service) ConsumeRecoveryCode(userId primitive.ObjectID, codeHash string) bool {
+	filter := bson.M{"_id": userId, "recovery_codes": codeHash}
+	updateFilter := bson.M{
+		"$pull": bson.M{"recovery_codes": codeHash},
+	}
+	result, err := UserCollection.UpdateOne(context.Background(), filter, updateFilter)
+	if err != nil {
+		return false
+	}
+	return result.ModifiedCount == 1
+}
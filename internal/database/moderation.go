@@ -0,0 +1,156 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"silent-notes/internal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrReportNotFound     = errors.New("report not found")
+	ErrReportExists       = errors.New("message already reported")
+	ErrSuspensionNotFound = errors.New("suspension not found")
+)
+
+// CreateReport files a report, a message can only have one open report.
+func (s *service) CreateReport(report models.Report) error {
+	_, err := ReportCollection.InsertOne(context.Background(), report)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrReportExists
+	}
+	return err
+}
+
+// GetReports lists reports with the given status, oldest first so the queue
+// is worked in order. An empty status or category matches all.
+func (s *service) GetReports(status, category string, limit, skip int64) ([]models.Report, error) {
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+	if category != "" {
+		filter["category"] = category
+	}
+	cursor, err := ReportCollection.Find(context.Background(), filter,
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}).SetLimit(limit).SetSkip(skip))
+	if err != nil {
+		return nil, err
+	}
+
+	reports := []models.Report{}
+	if err := cursor.All(context.Background(), &reports); err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
+func (s *service) GetReport(reportId primitive.ObjectID) (*models.Report, error) {
+	var report models.Report
+	err := ReportCollection.FindOne(context.Background(), bson.M{"_id": reportId}).Decode(&report)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrReportNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// ResolveReport closes an open report, ErrReportNotFound if it is already closed.
+func (s *service) ResolveReport(reportId primitive.ObjectID, status string, moderatorId primitive.ObjectID, note string) error {
+	result, err := ReportCollection.UpdateOne(context.Background(),
+		bson.M{"_id": reportId, "status": models.ReportOpen},
+		bson.M{"$set": bson.M{"status": status, "resolved_at": time.Now(), "resolved_by": moderatorId, "note": note}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrReportNotFound
+	}
+	return nil
+}
+
+// RemoveMessage deletes a message for good, skipping the trash.
+func (s *service) RemoveMessage(messageId primitive.ObjectID) error {
+	_, err := MessageCollection.DeleteOne(context.Background(), bson.M{"_id": messageId})
+	return err
+}
+
+func (s *service) AddSuspension(suspension models.Suspension) error {
+	_, err := SuspensionCollection.InsertOne(context.Background(), suspension)
+	return err
+}
+
+func (s *service) GetSuspensions() ([]models.Suspension, error) {
+	cursor, err := SuspensionCollection.Find(context.Background(), activeSuspension(bson.M{}),
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	suspensions := []models.Suspension{}
+	if err := cursor.All(context.Background(), &suspensions); err != nil {
+		return nil, err
+	}
+	return suspensions, nil
+}
+
+func (s *service) DeleteSuspension(suspensionId primitive.ObjectID) (*models.Suspension, error) {
+	var suspension models.Suspension
+	err := SuspensionCollection.FindOneAndDelete(context.Background(), bson.M{"_id": suspensionId}).Decode(&suspension)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrSuspensionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &suspension, nil
+}
+
+func (s *service) IsSenderSuspended(fingerprints []string) (bool, error) {
+	return suspended(activeSuspension(bson.M{"kind": models.SuspendSender, "fingerprint": bson.M{"$in": fingerprints}}))
+}
+
+func (s *service) IsUserSuspended(userId primitive.ObjectID) (bool, error) {
+	return suspended(activeSuspension(bson.M{"kind": models.SuspendUser, "user_id": userId}))
+}
+
+// activeSuspension skips suspensions the TTL monitor has not removed yet.
+func activeSuspension(filter bson.M) bson.M {
+	filter["$or"] = bson.A{bson.M{"expires_at": nil}, bson.M{"expires_at": bson.M{"$gt": time.Now()}}}
+	return filter
+}
+
+func suspended(filter bson.M) (bool, error) {
+	count, err := SuspensionCollection.CountDocuments(context.Background(), filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// LogModeration appends to the moderation log, entries are never changed.
+func (s *service) LogModeration(action models.ModerationAction) error {
+	_, err := ModerationLogCollection.InsertOne(context.Background(), action)
+	return err
+}
+
+func (s *service) GetModerationLog(limit, skip int64) ([]models.ModerationAction, error) {
+	cursor, err := ModerationLogCollection.Find(context.Background(), bson.M{},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(limit).SetSkip(skip))
+	if err != nil {
+		return nil, err
+	}
+
+	actions := []models.ModerationAction{}
+	if err := cursor.All(context.Background(), &actions); err != nil {
+		return nil, err
+	}
+	return actions, nil
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"silent-notes/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrPromptNotFound = errors.New("prompt not found")
+	// ErrPromptConflict means the slug is taken or the user has no prompts left
+	ErrPromptConflict = errors.New("prompt slug already used or prompt limit reached")
+)
+
+func (s *service) AddPrompt(userId primitive.ObjectID, prompt models.Prompt) error {
+	result, err := UserCollection.UpdateOne(context.Background(),
+		bson.M{
+			"_id":          userId,
+			"prompts.slug": bson.M{"$ne": prompt.Slug},
+			fmt.Sprintf("prompts.%d", models.MaxPrompts-1): bson.M{"$exists": false},
+		},
+		bson.M{"$push": bson.M{"prompts": prompt}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrPromptConflict
+	}
+	return nil
+}
+
+// UpdatePrompt saves the editable fields of a prompt, the message count is
+// kept.
+func (s *service) UpdatePrompt(userId primitive.ObjectID, prompt models.Prompt) error {
+	result, err := UserCollection.UpdateOne(context.Background(),
+		bson.M{
+			"_id": userId,
+			"$and": []bson.M{
+				{"prompts._id": prompt.ID},
+				{"prompts": bson.M{"$not": bson.M{"$elemMatch": bson.M{"slug": prompt.Slug, "_id": bson.M{"$ne": prompt.ID}}}}},
+			},
+		},
+		bson.M{"$set": bson.M{
+			"prompts.$[p].slug":         prompt.Slug,
+			"prompts.$[p].title":        prompt.Title,
+			"prompts.$[p].description":  prompt.Description,
+			"prompts.$[p].is_open":      prompt.IsOpen,
+			"prompts.$[p].max_messages": prompt.MaxMessages,
+		}},
+		options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"p._id": prompt.ID}}}))
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	// nothing matched, either the prompt is gone or the slug is taken
+	count, err := UserCollection.CountDocuments(context.Background(), bson.M{"_id": userId, "prompts._id": prompt.ID})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrPromptNotFound
+	}
+	return ErrPromptConflict
+}
+
+// DeletePrompt removes the prompt, its messages stay in the inbox.
+func (s *service) DeletePrompt(userId, promptId primitive.ObjectID) error {
+	result, err := UserCollection.UpdateOne(context.Background(),
+		bson.M{"_id": userId, "prompts._id": promptId},
+		bson.M{"$pull": bson.M{"prompts": bson.M{"_id": promptId}}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrPromptNotFound
+	}
+	return nil
+}
+
+// CountPromptMessage takes one of the messages the prompt still accepts, it
+// reports false once the prompt is closed or full.
+func (s *service) CountPromptMessage(userId primitive.ObjectID, prompt models.Prompt) (bool, error) {
+	match := bson.M{"_id": prompt.ID, "is_open": true}
+	if prompt.MaxMessages > 0 {
+		match["received_count"] = bson.M{"$lt": prompt.MaxMessages}
+	}
+	result, err := UserCollection.UpdateOne(context.Background(),
+		bson.M{"_id": userId, "prompts": bson.M{"$elemMatch": match}},
+		bson.M{"$inc": bson.M{"prompts.$.received_count": 1}})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// ReleasePromptMessage gives back a message CountPromptMessage took.
+func (s *service) ReleasePromptMessage(userId, promptId primitive.ObjectID) error {
+	_, err := UserCollection.UpdateOne(context.Background(),
+		bson.M{"_id": userId, "prompts": bson.M{"$elemMatch": bson.M{"_id": promptId, "received_count": bson.M{"$gt": 0}}}},
+		bson.M{"$inc": bson.M{"prompts.$.received_count": -1}})
+	return err
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"silent-notes/internal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+func (s *service) CreateSession(session models.Session) error {
+	_, err := SessionCollection.InsertOne(context.Background(), session)
+	return err
+}
+
+// GetSession returns a live session of the user. Expired ones are skipped as
+// the TTL monitor only runs once a minute.
+func (s *service) GetSession(userId, sessionId primitive.ObjectID) (*models.Session, error) {
+	var session models.Session
+	err := SessionCollection.FindOne(context.Background(),
+		bson.M{"_id": sessionId, "user_id": userId, "expires_at": bson.M{"$gt": time.Now()}}).Decode(&session)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// TouchSession records that the session was just used and from where.
+func (s *service) TouchSession(sessionId primitive.ObjectID, at time.Time, ip string) error {
+	_, err := SessionCollection.UpdateByID(context.Background(), sessionId,
+		bson.M{"$set": bson.M{"last_seen_at": at, "ip": ip}})
+	return err
+}
+
+// GetSessions lists the user's live sessions, most recently used first.
+func (s *service) GetSessions(userId primitive.ObjectID) ([]models.Session, error) {
+	cursor, err := SessionCollection.Find(context.Background(),
+		bson.M{"user_id": userId, "expires_at": bson.M{"$gt": time.Now()}},
+		options.Find().SetSort(bson.D{{Key: "last_seen_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []models.Session{}
+	if err := cursor.All(context.Background(), &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (s *service) DeleteSession(userId, sessionId primitive.ObjectID) error {
+	result, err := SessionCollection.DeleteOne(context.Background(), bson.M{"_id": sessionId, "user_id": userId})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// DeleteSessions signs the user out everywhere except the session keep,
+// which may be the zero id to sign them out everywhere.
+func (s *service) DeleteSessions(userId, keep primitive.ObjectID) (int64, error) {
+	filter := bson.M{"user_id": userId}
+	if !keep.IsZero() {
+		filter["_id"] = bson.M{"$ne": keep}
+	}
+	result, err := SessionCollection.DeleteMany(context.Background(), filter)
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
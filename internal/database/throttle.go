@@ -0,0 +1,82 @@
+package database
+
+import (
+	"context"
+	"silent-notes/internal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (s *service) GetAuthThrottle(key string) *models.AuthThrottle {
+	var throttle models.AuthThrottle
+	err := AuthThrottleCollection.FindOne(context.Background(), bson.M{"_id": key}).Decode(&throttle)
+	if err != nil {
+		return nil
+	}
+	return &throttle
+}
+
+// RecordAuthFailure counts a failure for key, restarting the count when the
+// previous failure is older than window.
+func (s *service) RecordAuthFailure(key string, window time.Duration) (*models.AuthThrottle, error) {
+	now := time.Now()
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"failures": bson.M{"$cond": bson.A{
+				bson.M{"$lt": bson.A{"$last_failure_at", now.Add(-window)}},
+				1,
+				bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$failures", 0}}, 1}},
+			}},
+			"last_failure_at": now,
+		}}},
+	}
+
+	var throttle models.AuthThrottle
+	err := AuthThrottleCollection.FindOneAndUpdate(context.Background(), bson.M{"_id": key}, update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&throttle)
+	if err != nil {
+		return nil, err
+	}
+	return &throttle, nil
+}
+
+func (s *service) LockAuthKey(key string, until time.Time) error {
+	_, err := AuthThrottleCollection.UpdateByID(context.Background(), key, bson.M{
+		"$set": bson.M{"locked_until": until},
+	})
+	return err
+}
+
+func (s *service) ClearAuthFailures(key string) error {
+	_, err := AuthThrottleCollection.DeleteOne(context.Background(), bson.M{"_id": key})
+	return err
+}
+
+// IncrementVerifyAttempts counts a wrong verification code and returns the
+// total since the code was issued.
+func (s *service) IncrementVerifyAttempts(userId primitive.ObjectID) (int, error) {
+	var user models.UserModel
+	err := UserCollection.FindOneAndUpdate(context.Background(), bson.M{"_id": userId},
+		bson.M{"$inc": bson.M{"verify_attempts": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.M{"verify_attempts": 1})).Decode(&user)
+	if err != nil {
+		return 0, err
+	}
+	return user.VerifyAttempts, nil
+}
+
+// InvalidateVerifyCode drops the current code, a new one is mailed on the next sign-in.
+func (s *service) InvalidateVerifyCode(userId primitive.ObjectID) error {
+	_, err := UserCollection.UpdateByID(context.Background(), userId, bson.M{
+		"$unset": bson.M{
+			"verify_code":        "",
+			"verify_code_expiry": "",
+			"verify_attempts":    "",
+		},
+	})
+	return err
+}
@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"errors"
+	"regexp"
 	"silent-notes/internal/models"
 	"time"
 
@@ -12,10 +13,13 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+var ErrUsernameTaken = errors.New("username already taken")
+
 func (s *service) CheckExistingUser(username, email string) bool {
 	filter := bson.M{"$or": []bson.M{
 		{"email": email},
 		{"username": username},
+		activeAliasFilter(username),
 	}}
 	err := UserCollection.FindOne(context.Background(), filter, options.FindOne().SetProjection(bson.M{"password": 0})).Err()
 
@@ -40,6 +44,7 @@ func (s *service) GetUser(identifier, projection string) *models.UserModel {
 	filter := bson.M{"$or": []bson.M{
 		{"email": identifier},
 		{"username": identifier},
+		activeAliasFilter(identifier),
 	}}
 	var err error
 	if projection == "" {
@@ -55,6 +60,24 @@ func (s *service) GetUser(identifier, projection string) *models.UserModel {
 	return &user
 }
 
+// activeAliasFilter matches a user who used to be called username and is
+// still inside the rename grace period.
+func activeAliasFilter(username string) bson.M {
+	return bson.M{"previous_usernames": bson.M{"$elemMatch": bson.M{
+		"username": username,
+		"until":    bson.M{"$gt": time.Now()},
+	}}}
+}
+
+func (s *service) GetUserByID(userId primitive.ObjectID) *models.UserModel {
+	var user models.UserModel
+	err := UserCollection.FindOne(context.Background(), bson.M{"_id": userId}).Decode(&user)
+	if err != nil {
+		return nil
+	}
+	return &user
+}
+
 func (s *service) VerifyUser(username string) (interface{}, error) {
 
 	filter := bson.M{
@@ -65,6 +88,7 @@ func (s *service) VerifyUser(username string) (interface{}, error) {
 		"$unset": bson.M{
 			"verify_code":        "",
 			"verify_code_expiry": "",
+			"verify_attempts":    "",
 		},
 	}
 
@@ -81,6 +105,7 @@ func (s *service) ReVerifyCode(userId primitive.ObjectID, verifyCode int, verify
 			"verify_code":        verifyCode,
 			"verify_code_expiry": verifyCodeExpiry,
 		},
+		"$unset": bson.M{"verify_attempts": ""},
 	}
 	result, err := UserCollection.UpdateByID(context.Background(), userId, updateFilter)
 	if err != nil {
@@ -107,57 +132,80 @@ func (s *service) ToggleAcceptMessages(isAcceptingMessages bool, userId primitiv
 	return true
 }
 
-func (s *service) AddMessage(username string, message models.Message) error {
-	filter := bson.M{"username": username}
+func (s *service) SetMagicLinkID(userId primitive.ObjectID, jti string) error {
 	updateFilter := bson.M{
-		"$push": bson.M{
-			"messages": message,
-		},
+		"$set": bson.M{"magic_link_id": jti},
 	}
-	result, err := UserCollection.UpdateOne(context.Background(), filter, updateFilter)
+	result, err := UserCollection.UpdateByID(context.Background(), userId, updateFilter)
 	if err != nil {
 		return err
 	}
 	if result.MatchedCount == 0 {
 		return errors.New("user not found")
 	}
-
 	return nil
 }
 
-func (s *service) GetMessages(userId primitive.ObjectID) ([]models.Message, error) {
+// ConsumeMagicLink clears the outstanding link id, it reports false when the
+// link was already used or replaced by a newer one.
+func (s *service) ConsumeMagicLink(userId primitive.ObjectID, jti string) bool {
+	filter := bson.M{"_id": userId, "magic_link_id": jti}
+	updateFilter := bson.M{
+		"$unset": bson.M{"magic_link_id": ""},
+	}
+	result, err := UserCollection.UpdateOne(context.Background(), filter, updateFilter)
+	if err != nil {
+		return false
+	}
+	return result.ModifiedCount == 1
+}
 
+func (s *service) GetUserByIdentity(provider, subject string) *models.UserModel {
 	var user models.UserModel
-	var userMessages []models.Message
-	result := UserCollection.FindOne(context.Background(), bson.M{"_id": userId}, options.FindOne().SetProjection(bson.M{
-		"_id":                   0,
-		"username":              0,
-		"email":                 0,
-		"password":              0,
-		"is_accepting_messages": 0,
-		"is_verified":           0,
-	}))
-	err := result.Decode(&user)
-	userMessages = user.Messages
-	if err == mongo.ErrNoDocuments {
-		return nil, nil
-	} else if err != nil {
-		return nil, err
+	filter := bson.M{"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}}}
+	err := UserCollection.FindOne(context.Background(), filter).Decode(&user)
+	if err != nil {
+		return nil
+	}
+	return &user
+}
+
+func (s *service) LinkIdentity(userId primitive.ObjectID, identity models.ExternalIdentity) error {
+	filter := bson.M{
+		"_id":        userId,
+		"identities": bson.M{"$not": bson.M{"$elemMatch": bson.M{"provider": identity.Provider, "subject": identity.Subject}}},
+	}
+	updateFilter := bson.M{
+		"$push": bson.M{"identities": identity},
 	}
+	_, err := UserCollection.UpdateOne(context.Background(), filter, updateFilter)
+	return err
+}
 
-	if len(userMessages) == 0 {
-		return nil, nil
+func (s *service) UpdatePassword(userId primitive.ObjectID, hashedPassword string) error {
+	updateFilter := bson.M{
+		"$set": bson.M{"password": hashedPassword},
+	}
+	result, err := UserCollection.UpdateByID(context.Background(), userId, updateFilter)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("user not found")
 	}
-	return userMessages, nil
+	return nil
 }
 
-func (s *service) DeleteMessage(userId, messageId primitive.ObjectID) error {
+// SetPendingEmail parks the new address until it is confirmed with the
+// verify code mailed to it.
+func (s *service) SetPendingEmail(userId primitive.ObjectID, email string, verifyCode int, verifyCodeExpiry time.Time) error {
 	updateFilter := bson.M{
-		"$pull": bson.M{
-			"messages": bson.M{
-				"_id": messageId,
-			},
+		"$set": bson.M{
+			"pending_email":      email,
+			"verify_code":        verifyCode,
+			"verify_code_expiry": verifyCodeExpiry,
 		},
+		"$unset": bson.M{"verify_attempts": ""},
 	}
 	result, err := UserCollection.UpdateByID(context.Background(), userId, updateFilter)
 	if err != nil {
@@ -168,3 +216,214 @@ func (s *service) DeleteMessage(userId, messageId primitive.ObjectID) error {
 	}
 	return nil
 }
+
+func (s *service) ConfirmPendingEmail(userId primitive.ObjectID) error {
+	filter := bson.M{"_id": userId, "pending_email": bson.M{"$exists": true}}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"email": "$pending_email"}}},
+		{{Key: "$unset", Value: bson.A{"pending_email", "verify_code", "verify_code_expiry", "verify_attempts"}}},
+	}
+	result, err := UserCollection.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("no pending email change")
+	}
+	return nil
+}
+
+// UsernameTaken reports whether username belongs to, or is still reserved as
+// an alias by, anyone other than exceptUserId.
+func (s *service) UsernameTaken(username string, exceptUserId primitive.ObjectID) bool {
+	filter := bson.M{
+		"_id": bson.M{"$ne": exceptUserId},
+		"$or": []bson.M{
+			{"username": username},
+			activeAliasFilter(username),
+		},
+	}
+	count, err := UserCollection.CountDocuments(context.Background(), filter)
+	if err != nil {
+		return true
+	}
+	return count > 0
+}
+
+// ChangeUsername renames the user and keeps the old name as an alias until
+// aliasUntil. Expired aliases and one matching the new name are dropped.
+func (s *service) ChangeUsername(userId primitive.ObjectID, oldUsername, newUsername string, aliasUntil time.Time) error {
+	filter := bson.M{"_id": userId, "username": oldUsername}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"username": newUsername,
+			"previous_usernames": bson.M{"$concatArrays": bson.A{
+				bson.M{"$filter": bson.M{
+					"input": bson.M{"$ifNull": bson.A{"$previous_usernames", bson.A{}}},
+					"as":    "alias",
+					"cond": bson.M{"$and": bson.A{
+						bson.M{"$ne": bson.A{"$$alias.username", newUsername}},
+						bson.M{"$gt": bson.A{"$$alias.until", time.Now()}},
+					}},
+				}},
+				bson.A{bson.M{"username": oldUsername, "until": aliasUntil}},
+			}},
+		}}},
+	}
+	result, err := UserCollection.UpdateOne(context.Background(), filter, update)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrUsernameTaken
+	} else if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+func (s *service) ScheduleAccountDeletion(userId primitive.ObjectID, at time.Time) error {
+	updateFilter := bson.M{
+		"$set": bson.M{"deletion_scheduled_at": at},
+	}
+	result, err := UserCollection.UpdateByID(context.Background(), userId, updateFilter)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+func (s *service) CancelAccountDeletion(userId primitive.ObjectID) error {
+	updateFilter := bson.M{
+		"$unset": bson.M{"deletion_scheduled_at": ""},
+	}
+	_, err := UserCollection.UpdateByID(context.Background(), userId, updateFilter)
+	return err
+}
+
+func (s *service) GetUsersDueForDeletion(before time.Time) ([]models.UserModel, error) {
+	filter := bson.M{"deletion_scheduled_at": bson.M{"$lte": before}}
+	cursor, err := UserCollection.Find(context.Background(), filter, options.Find().SetProjection(bson.M{
+		"_id":                   1,
+		"username":              1,
+		"email":                 1,
+		"deletion_scheduled_at": 1,
+	}))
+	if err != nil {
+		return nil, err
+	}
+	var users []models.UserModel
+	if err := cursor.All(context.Background(), &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// PurgeUser removes everything stored for the user. The user document goes
+// last so a failed purge is retried by the next sweep.
+func (s *service) PurgeUser(userId primitive.ObjectID) error {
+	ctx := context.Background()
+
+	if _, err := MessageCollection.DeleteMany(ctx, bson.M{"user_id": userId}); err != nil {
+		return err
+	}
+	if _, err := DroppedCollection.DeleteMany(ctx, bson.M{"user_id": userId}); err != nil {
+		return err
+	}
+	if _, err := AuthThrottleCollection.DeleteOne(ctx, bson.M{"_id": "account:" + userId.Hex()}); err != nil {
+		return err
+	}
+	if _, err := DataExportCollection.DeleteMany(ctx, bson.M{"user_id": userId}); err != nil {
+		return err
+	}
+	if _, err := BlockCollection.DeleteMany(ctx, bson.M{"user_id": userId}); err != nil {
+		return err
+	}
+	// reports stay for the moderators and the moderation log that points at
+	// them, only the link to the account goes
+	if _, err := ReportCollection.UpdateMany(ctx, bson.M{"reporter_id": userId}, bson.M{"$unset": bson.M{"reporter_id": ""}}); err != nil {
+		return err
+	}
+	if _, err := SessionCollection.DeleteMany(ctx, bson.M{"user_id": userId}); err != nil {
+		return err
+	}
+	// admin actions taken on the account stay with the admin's events, and a
+	// deleted staff account's own admin actions stay in the trail too
+	if _, err := AuditCollection.DeleteMany(ctx, bson.M{
+		"user_id": userId,
+		"action":  bson.M{"$not": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(models.AuditAdminPrefix)}},
+	}); err != nil {
+		return err
+	}
+	if _, err := UserCollection.DeleteOne(ctx, bson.M{"_id": userId}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *service) SetRetentionPolicy(userId primitive.ObjectID, policy models.RetentionPolicy) error {
+	_, err := UserCollection.UpdateByID(context.Background(), userId, bson.M{"$set": bson.M{"retention": policy}})
+	return err
+}
+
+// SetAcceptSchedule replaces the user's schedule, restarting the message count.
+func (s *service) SetAcceptSchedule(userId primitive.ObjectID, schedule *models.AcceptSchedule) error {
+	var update bson.M
+	if schedule == nil {
+		update = bson.M{"$unset": bson.M{"schedule": ""}}
+	} else {
+		schedule.ReceivedCount = 0
+		update = bson.M{"$set": bson.M{"schedule": schedule}}
+	}
+	_, err := UserCollection.UpdateByID(context.Background(), userId, update)
+	return err
+}
+
+// CountScheduledMessage takes one of the messages the schedule still allows,
+// it reports false once the limit has been reached.
+func (s *service) CountScheduledMessage(userId primitive.ObjectID, maxMessages int) (bool, error) {
+	filter := bson.M{"_id": userId, "schedule": bson.M{"$ne": nil}}
+	if maxMessages > 0 {
+		filter["schedule.received_count"] = bson.M{"$lt": maxMessages}
+	}
+	result, err := UserCollection.UpdateOne(context.Background(), filter, bson.M{"$inc": bson.M{"schedule.received_count": 1}})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// ReleaseScheduledMessage gives back a message CountScheduledMessage took.
+func (s *service) ReleaseScheduledMessage(userId primitive.ObjectID) error {
+	_, err := UserCollection.UpdateOne(context.Background(),
+		bson.M{"_id": userId, "schedule.received_count": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"schedule.received_count": -1}})
+	return err
+}
+
+// UpdateProfile saves the text fields of the profile, the avatar is changed
+// through SetAvatar.
+func (s *service) UpdateProfile(userId primitive.ObjectID, profile models.UserProfile) error {
+	_, err := UserCollection.UpdateByID(context.Background(), userId, bson.M{"$set": bson.M{
+		"profile.display_name": profile.DisplayName,
+		"profile.bio":          profile.Bio,
+		"profile.prompt_text":  profile.PromptText,
+	}})
+	return err
+}
+
+// SetAvatar points the profile at newly stored avatar blobs, an empty avatar
+// removes it.
+func (s *service) SetAvatar(userId primitive.ObjectID, avatar string, urls map[string]string, keys []string) error {
+	var update bson.M
+	if avatar == "" {
+		update = bson.M{"$unset": bson.M{"profile.avatar": "", "profile.avatar_urls": "", "profile.avatar_keys": ""}}
+	} else {
+		update = bson.M{"$set": bson.M{"profile.avatar": avatar, "profile.avatar_urls": urls, "profile.avatar_keys": keys}}
+	}
+	_, err := UserCollection.UpdateByID(context.Background(), userId, update)
+	return err
+}
@@ -3,49 +3,96 @@ package middlewares
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"silent-notes/internal/database"
 	"silent-notes/internal/types"
 	"silent-notes/internal/utils"
 	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-func Auth(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-
-		token, err := r.Cookie("token")
-		if err == http.ErrNoCookie {
-			w.WriteHeader(http.StatusUnauthorized)
-			res := types.Response{StatusCode: http.StatusUnauthorized, Success: false, Message: "Unauthorized", Error: "Unauthorized"}
-			json.NewEncoder(w).Encode(res)
-			return
-		}
-
-		claims, err := utils.VerifyJWT(token.Value)
-		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			res := types.Response{StatusCode: http.StatusUnauthorized, Success: false, Message: "Unauthorized", Error: "Unauthorized"}
-			json.NewEncoder(w).Encode(res)
-			return
-		}
-
-		exp, ok := claims["exp"].(float64)
-		if !ok {
-			w.WriteHeader(http.StatusUnauthorized)
-			res := types.Response{StatusCode: http.StatusUnauthorized, Success: false, Message: "Unauthorized", Error: "Invalid expiration claim"}
-			json.NewEncoder(w).Encode(res)
-			return
-		}
-
-		if time.Now().After(time.Unix(int64(exp), 0)) {
-			w.WriteHeader(http.StatusUnauthorized)
-			res := types.Response{StatusCode: http.StatusUnauthorized, Success: false, Message: "Unauthorized", Error: "Cookie Expired"}
-			json.NewEncoder(w).Encode(res)
-			return
-		}
-
-		userId := claims["user_id"].(string)
-		ctx := context.WithValue(r.Context(), types.UserIDKey, userId)
-
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+// sessionTouchInterval limits how often a session's last seen time is
+// written, once per request would be a write for every read.
+const sessionTouchInterval = time.Minute
+
+// Auth lets a request through only with a valid session token whose session
+// has not been revoked.
+func Auth(db database.Service) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			token, err := r.Cookie("token")
+			if err == http.ErrNoCookie {
+				w.WriteHeader(http.StatusUnauthorized)
+				res := types.Response{StatusCode: http.StatusUnauthorized, Success: false, Message: "Unauthorized", Error: "Unauthorized"}
+				json.NewEncoder(w).Encode(res)
+				return
+			}
+
+			claims, err := utils.VerifyPurposeJWT(token.Value, utils.PurposeSession)
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				res := types.Response{StatusCode: http.StatusUnauthorized, Success: false, Message: "Unauthorized", Error: "Unauthorized"}
+				json.NewEncoder(w).Encode(res)
+				return
+			}
+
+			exp, ok := claims["exp"].(float64)
+			if !ok {
+				w.WriteHeader(http.StatusUnauthorized)
+				res := types.Response{StatusCode: http.StatusUnauthorized, Success: false, Message: "Unauthorized", Error: "Invalid expiration claim"}
+				json.NewEncoder(w).Encode(res)
+				return
+			}
+
+			if time.Now().After(time.Unix(int64(exp), 0)) {
+				w.WriteHeader(http.StatusUnauthorized)
+				res := types.Response{StatusCode: http.StatusUnauthorized, Success: false, Message: "Unauthorized", Error: "Cookie Expired"}
+				json.NewEncoder(w).Encode(res)
+				return
+			}
+
+			userId := claims["user_id"].(string)
+
+			// tokens without a session cannot be revoked, so they are refused
+			sid, _ := claims["sid"].(string)
+			userIdObjectId, userErr := primitive.ObjectIDFromHex(userId)
+			sessionId, sidErr := primitive.ObjectIDFromHex(sid)
+			if userErr != nil || sidErr != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				res := types.Response{StatusCode: http.StatusUnauthorized, Success: false, Message: "Unauthorized", Error: "Unauthorized"}
+				json.NewEncoder(w).Encode(res)
+				return
+			}
+
+			session, err := db.GetSession(userIdObjectId, sessionId)
+			if errors.Is(err, database.ErrSessionNotFound) {
+				w.WriteHeader(http.StatusUnauthorized)
+				res := types.Response{StatusCode: http.StatusUnauthorized, Success: false, Message: "Unauthorized", Error: "Session Revoked"}
+				json.NewEncoder(w).Encode(res)
+				return
+			}
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+				json.NewEncoder(w).Encode(res)
+				return
+			}
+
+			now := time.Now()
+			if now.Sub(session.LastSeenAt) > sessionTouchInterval {
+				if err := db.TouchSession(sessionId, now, utils.ClientIP(r)); err != nil {
+					log.Printf("error touching session %s: %v", sid, err)
+				}
+			}
+
+			ctx := context.WithValue(r.Context(), types.UserIDKey, userId)
+			ctx = context.WithValue(ctx, types.SessionIDKey, sid)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
 }
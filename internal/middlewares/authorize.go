@@ -0,0 +1,39 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"net/http"
+	"silent-notes/internal/database"
+	"silent-notes/internal/models"
+	"silent-notes/internal/types"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Authorize lets a request through only if the signed in user's role grants
+// permission. It has to run after Auth. The role is read on every request so
+// a demotion takes effect right away.
+func Authorize(db database.Service, permission models.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userId, _ := r.Context().Value(types.UserIDKey).(string)
+			userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				res := types.Response{StatusCode: http.StatusUnauthorized, Success: false, Message: "Unauthorized", Error: "Unauthorized"}
+				json.NewEncoder(w).Encode(res)
+				return
+			}
+
+			user := db.GetUserByID(userIdObjectId)
+			if user == nil || !user.Can(permission) {
+				w.WriteHeader(http.StatusForbidden)
+				res := types.Response{StatusCode: http.StatusForbidden, Success: false, Message: "Forbidden", Error: "missing permission " + string(permission)}
+				json.NewEncoder(w).Encode(res)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Audit actions. Admin actions are recorded as "admin." followed by the
+// moderation log action, e.g. "admin.suspend.user".
+const (
+	AuditSignIn                = "auth.sign_in"
+	AuditSignInFailed          = "auth.sign_in_failed"
+	AuditSignOut               = "auth.sign_out"
+	AuditSessionRevoked        = "auth.session_revoked"
+	AuditSessionsRevoked       = "auth.sessions_revoked"
+	AuditVerified              = "account.verified"
+	AuditAcceptMessages        = "account.accept_messages"
+	AuditPasswordChanged       = "account.password_changed"
+	AuditEmailChangeRequested  = "account.email_change_requested"
+	AuditEmailChanged          = "account.email_changed"
+	AuditUsernameChanged       = "account.username_changed"
+	AuditDeletionScheduled     = "account.deletion_scheduled"
+	AuditDeletionCancelled     = "account.deletion_cancelled"
+	AuditMFAEnabled            = "mfa.enabled"
+	AuditMFADisabled           = "mfa.disabled"
+	AuditRecoveryCodesReplaced = "mfa.recovery_codes_replaced"
+	AuditMessageDeleted        = "messages.deleted"
+	AuditTrashEmptied          = "messages.trash_emptied"
+	AuditAdminPrefix           = "admin."
+)
+
+// AuditEvent is one entry of the append-only audit trail, only ever removed
+// once it is older than the audit retention or, unless it is an admin action,
+// when its account is deleted. UserID is the account that acted, or whose
+// credentials were tried, TargetID the account an admin action was taken on.
+type AuditEvent struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Action    string             `json:"action" bson:"action"`
+	TargetID  primitive.ObjectID `json:"target_id,omitempty" bson:"target_id,omitempty"`
+	Details   map[string]string  `json:"details,omitempty" bson:"details,omitempty"`
+	IP        string             `json:"ip" bson:"ip"`
+	UserAgent string             `json:"user_agent" bson:"user_agent"`
+	RequestID string             `json:"request_id,omitempty" bson:"request_id,omitempty"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// AuditFilter narrows an audit query. An action ending in "." matches every
+// action under it, WithTargeted also includes admin actions on the user.
+type AuditFilter struct {
+	UserID       primitive.ObjectID
+	Action       string
+	WithTargeted bool
+}
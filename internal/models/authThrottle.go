@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// AuthThrottle counts recent failed attempts for one key, an account
+// ("account:<id>") or a client address ("ip:<addr>").
+type AuthThrottle struct {
+	Key           string    `json:"key" bson:"_id"`
+	Failures      int       `json:"failures" bson:"failures"`
+	LastFailureAt time.Time `json:"last_failure_at" bson:"last_failure_at"`
+	LockedUntil   time.Time `json:"locked_until,omitempty" bson:"locked_until,omitempty"`
+}
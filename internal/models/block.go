@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SenderBlock drops messages from a sender the user blocked from one of their
+// messages. The fingerprint never leaves the server.
+type SenderBlock struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	UserID      primitive.ObjectID `json:"-" bson:"user_id"`
+	MessageID   primitive.ObjectID `json:"message_id" bson:"message_id"`
+	Fingerprint string             `json:"-" bson:"fingerprint"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	// ExpiresAt is when the fingerprint's salt has rotated out and the block
+	// can no longer match
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+}
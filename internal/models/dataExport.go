@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	ExportPending    = "pending"
+	ExportProcessing = "processing"
+	ExportReady      = "ready"
+	ExportFailed     = "failed"
+)
+
+type DataExport struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	UserID      primitive.ObjectID `json:"-" bson:"user_id"`
+	Status      string             `json:"status" bson:"status"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	ClaimedAt   time.Time          `json:"-" bson:"claimed_at,omitempty"`
+	Attempts    int                `json:"-" bson:"attempts,omitempty"`
+	CompletedAt time.Time          `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+	ExpiresAt   time.Time          `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	File        string             `json:"-" bson:"file,omitempty"`
+	Error       string             `json:"-" bson:"error,omitempty"`
+}
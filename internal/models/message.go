@@ -6,8 +6,90 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+const (
+	MessageUnread   = "unread"
+	MessageRead     = "read"
+	MessageArchived = "archived"
+)
+
+// bulk actions on a set of messages
+const (
+	MessageActionDelete   = "delete"
+	MessageActionArchive  = "archive"
+	MessageActionMarkRead = "mark_read"
+)
+
 type Message struct {
 	ID        primitive.ObjectID `json:"id,omitempty" bson:"_id"`
+	UserID    primitive.ObjectID `json:"-" bson:"user_id,omitempty"`
+	PromptID  primitive.ObjectID `json:"prompt_id,omitempty" bson:"prompt_id,omitempty"`
 	Content   string             `json:"content" bson:"content"`
+	State     string             `json:"state,omitempty" bson:"state,omitempty"`
 	CreatedAt time.Time          `json:"created_at,omitempty" bson:"created_at"`
+	ReadAt    *time.Time         `json:"read_at,omitempty" bson:"read_at,omitempty"`
+	TrashedAt *time.Time         `json:"trashed_at,omitempty" bson:"trashed_at,omitempty"`
+	ExpiresAt *time.Time         `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+
+	// Source and Medium come from the share link the sender arrived through
+	Source string `json:"source,omitempty" bson:"source,omitempty"`
+	Medium string `json:"medium,omitempty" bson:"medium,omitempty"`
+
+	// Reply is the owner's answer, the sender can read it with their token
+	// and send one FollowUp back.
+	Reply    *MessageReply `json:"reply,omitempty" bson:"reply,omitempty"`
+	FollowUp *MessageReply `json:"follow_up,omitempty" bson:"follow_up,omitempty"`
+	// SenderTokenHash identifies the sender's token without storing it
+	SenderTokenHash string `json:"-" bson:"sender_token_hash,omitempty"`
+	// Fingerprint lets the owner block the sender, it is cleared once its
+	// salt has rotated out
+	Fingerprint string `json:"-" bson:"fingerprint,omitempty"`
+}
+
+type MessageReply struct {
+	Content   string    `json:"content" bson:"content"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// SenderThread is what an anonymous sender sees of their message, nothing
+// about the recipient or the message's state in their inbox.
+type SenderThread struct {
+	Content     string        `json:"content"`
+	CreatedAt   time.Time     `json:"created_at"`
+	Reply       *MessageReply `json:"reply,omitempty"`
+	FollowUp    *MessageReply `json:"follow_up,omitempty"`
+	CanFollowUp bool          `json:"can_follow_up"`
+}
+
+func (m Message) SenderThread() SenderThread {
+	return SenderThread{
+		Content:     m.Content,
+		CreatedAt:   m.CreatedAt,
+		Reply:       m.Reply,
+		FollowUp:    m.FollowUp,
+		CanFollowUp: m.Reply != nil && m.FollowUp == nil,
+	}
+}
+
+// MessageSearchResult is a message found by a text search with its relevance.
+type MessageSearchResult struct {
+	Message `bson:",inline"`
+	Score   float64 `json:"score" bson:"score"`
+}
+
+// MessageFilter narrows a user's inbox, zero values match everything except
+// messages in the trash. To includes messages sent at that moment, Before
+// does not.
+type MessageFilter struct {
+	Trashed  bool
+	IDs      []primitive.ObjectID
+	PromptID primitive.ObjectID
+	From     time.Time
+	To       time.Time
+	Before   time.Time
+	State    string
+	Contains string
+}
+
+func IsMessageState(state string) bool {
+	return state == MessageUnread || state == MessageRead || state == MessageArchived
 }
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// what a message can be reported for
+var ReportCategories = []string{"spam", "harassment", "threat", "self_harm", "illegal", "other"}
+
+const (
+	ReportOpen      = "open"
+	ReportDismissed = "dismissed"
+	ReportRemoved   = "removed"
+)
+
+// Report flags a message for the moderators. It keeps a copy of what the
+// sender wrote and their fingerprint so the report still stands if the
+// recipient deletes the message or their account.
+type Report struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	MessageID   primitive.ObjectID `json:"message_id" bson:"message_id"`
+	ReporterID  primitive.ObjectID `json:"reporter_id,omitempty" bson:"reporter_id,omitempty"`
+	Category    string             `json:"category" bson:"category"`
+	Details     string             `json:"details,omitempty" bson:"details,omitempty"`
+	Content     string             `json:"content" bson:"content"`
+	FollowUp    string             `json:"follow_up,omitempty" bson:"follow_up,omitempty"`
+	Fingerprint string             `json:"-" bson:"fingerprint,omitempty"`
+	Status      string             `json:"status" bson:"status"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	ResolvedAt  *time.Time         `json:"resolved_at,omitempty" bson:"resolved_at,omitempty"`
+	ResolvedBy  primitive.ObjectID `json:"resolved_by,omitempty" bson:"resolved_by,omitempty"`
+	Note        string             `json:"note,omitempty" bson:"note,omitempty"`
+}
+
+const (
+	SuspendSender = "sender"
+	SuspendUser   = "user"
+)
+
+// Suspension shuts out an anonymous sender everywhere, by fingerprint, or an
+// account, which can then neither sign in nor receive messages.
+type Suspension struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	Kind        string             `json:"kind" bson:"kind"`
+	UserID      primitive.ObjectID `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	Fingerprint string             `json:"-" bson:"fingerprint,omitempty"`
+	ReportID    primitive.ObjectID `json:"report_id,omitempty" bson:"report_id,omitempty"`
+	Reason      string             `json:"reason" bson:"reason"`
+	CreatedBy   primitive.ObjectID `json:"created_by" bson:"created_by"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	ExpiresAt   *time.Time         `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+}
+
+// ModerationAction is one entry of the append-only moderation log.
+type ModerationAction struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	ModeratorID primitive.ObjectID `json:"moderator_id" bson:"moderator_id"`
+	Action      string             `json:"action" bson:"action"`
+	ReportID    primitive.ObjectID `json:"report_id,omitempty" bson:"report_id,omitempty"`
+	TargetID    primitive.ObjectID `json:"target_id,omitempty" bson:"target_id,omitempty"`
+	Note        string             `json:"note,omitempty" bson:"note,omitempty"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+}
+
+func IsReportCategory(category string) bool {
+	for _, c := range ReportCategories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package models
+
+import "time"
+
+// UserProfile is what the user chooses to show to people sending them notes.
+type UserProfile struct {
+	DisplayName string `json:"display_name" bson:"display_name,omitempty"`
+	Bio         string `json:"bio" bson:"bio,omitempty"`
+	PromptText  string `json:"prompt_text" bson:"prompt_text,omitempty"`
+	// Avatar is the default rendition, AvatarURLs has every size and format
+	Avatar     string            `json:"avatar" bson:"avatar,omitempty"`
+	AvatarURLs map[string]string `json:"avatar_urls,omitempty" bson:"avatar_urls,omitempty"`
+	AvatarKeys []string          `json:"-" bson:"avatar_keys,omitempty"`
+}
+
+// PublicProfile is everything an anonymous sender may see about a user. It is
+// built field by field so nothing private leaks when UserModel grows.
+type PublicProfile struct {
+	Username            string            `json:"username"`
+	DisplayName         string            `json:"display_name"`
+	Bio                 string            `json:"bio"`
+	PromptText          string            `json:"prompt_text"`
+	Avatar              string            `json:"avatar"`
+	AvatarURLs          map[string]string `json:"avatar_urls,omitempty"`
+	IsAcceptingMessages bool              `json:"is_accepting_messages"`
+	AcceptingNow        bool              `json:"accepting_now"`
+	OpensAt             *time.Time        `json:"opens_at"`
+	Retention           RetentionPolicy   `json:"retention"`
+	IsEphemeral         bool              `json:"is_ephemeral"`
+	Prompts             []PublicPrompt    `json:"prompts"`
+}
+
+type PublicPrompt struct {
+	Slug        string `json:"slug"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+}
+
+func (u *UserModel) PublicProfile(now time.Time) PublicProfile {
+	profile := PublicProfile{
+		Username:            u.Username,
+		DisplayName:         u.Profile.DisplayName,
+		Bio:                 u.Profile.Bio,
+		PromptText:          u.Profile.PromptText,
+		Avatar:              u.Profile.Avatar,
+		AvatarURLs:          u.Profile.AvatarURLs,
+		IsAcceptingMessages: u.IsAcceptingMessages,
+		AcceptingNow:        u.IsAcceptingMessages,
+		Retention:           u.Retention,
+		IsEphemeral:         u.Retention != (RetentionPolicy{}),
+		Prompts:             []PublicPrompt{},
+	}
+	if profile.DisplayName == "" {
+		profile.DisplayName = u.Username
+	}
+	if profile.AcceptingNow && u.Schedule != nil {
+		profile.AcceptingNow, profile.OpensAt = u.Schedule.IsOpen(now)
+	}
+	for _, prompt := range u.Prompts {
+		if prompt.AcceptsMessages() {
+			profile.Prompts = append(profile.Prompts, PublicPrompt{Slug: prompt.Slug, Title: prompt.Title, Description: prompt.Description})
+		}
+	}
+	return profile
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MaxPrompts is how many prompts a user can have at once.
+const MaxPrompts = 20
+
+// Prompt is a named inbox with its own link, such as "Ask me about my job".
+// Messages sent to it carry its ID.
+type Prompt struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id"`
+	Slug          string             `json:"slug" bson:"slug"`
+	Title         string             `json:"title" bson:"title"`
+	Description   string             `json:"description,omitempty" bson:"description,omitempty"`
+	IsOpen        bool               `json:"is_open" bson:"is_open"`
+	MaxMessages   int                `json:"max_messages,omitempty" bson:"max_messages,omitempty"`
+	ReceivedCount int                `json:"received_count" bson:"received_count"`
+	CreatedAt     time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// AcceptsMessages reports whether the prompt is open and below its limit.
+func (p Prompt) AcceptsMessages() bool {
+	return p.IsOpen && (p.MaxMessages == 0 || p.ReceivedCount < p.MaxMessages)
+}
+
+// FindPrompt returns the user's prompt with the given slug, nil if none.
+func (u *UserModel) FindPrompt(slug string) *Prompt {
+	for i := range u.Prompts {
+		if u.Prompts[i].Slug == slug {
+			return &u.Prompts[i]
+		}
+	}
+	return nil
+}
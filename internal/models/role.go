@@ -0,0 +1,96 @@
+package models
+
+import "time"
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+type Permission string
+
+const (
+	PermReviewReports    Permission = "reports:review"
+	PermManageSuspension Permission = "suspensions:manage"
+	PermReadModeration   Permission = "moderation_log:read"
+	PermReadUsers        Permission = "users:read"
+	PermManageUsers      Permission = "users:manage"
+	PermReadStats        Permission = "stats:read"
+	PermManageRoles      Permission = "roles:manage"
+	PermReadAudit        Permission = "audit:read"
+)
+
+// rolePermissions is the whole access policy, admins can do everything.
+var rolePermissions = map[string][]Permission{
+	RoleUser: nil,
+	RoleModerator: {
+		PermReviewReports, PermManageSuspension, PermReadModeration, PermReadUsers,
+	},
+	RoleAdmin: {
+		PermReviewReports, PermManageSuspension, PermReadModeration, PermReadUsers,
+		PermManageUsers, PermReadStats, PermManageRoles, PermReadAudit,
+	},
+}
+
+func IsRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// EffectiveRole treats accounts created before roles existed as plain users.
+func (u *UserModel) EffectiveRole() string {
+	if u.Role == "" {
+		return RoleUser
+	}
+	return u.Role
+}
+
+func (u *UserModel) Can(permission Permission) bool {
+	for _, p := range rolePermissions[u.EffectiveRole()] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// UserSummary is what the admin API shows of an account, no secrets.
+type UserSummary struct {
+	ID                  string     `json:"id"`
+	Username            string     `json:"username"`
+	Email               string     `json:"email"`
+	Role                string     `json:"role"`
+	IsVerified          bool       `json:"is_verified"`
+	MFAEnabled          bool       `json:"mfa_enabled"`
+	CreatedAt           time.Time  `json:"created_at"`
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
+}
+
+func (u *UserModel) Summary() UserSummary {
+	summary := UserSummary{
+		ID:         u.ID.Hex(),
+		Username:   u.Username,
+		Email:      u.Email,
+		Role:       u.EffectiveRole(),
+		IsVerified: u.IsVerified,
+		MFAEnabled: u.MFAEnabled,
+		CreatedAt:  u.ID.Timestamp(),
+	}
+	if !u.DeletionScheduledAt.IsZero() {
+		summary.DeletionScheduledAt = &u.DeletionScheduledAt
+	}
+	return summary
+}
+
+// Stats is the admin dashboard's overview of the service.
+type Stats struct {
+	Users             int64 `json:"users"`
+	VerifiedUsers     int64 `json:"verified_users"`
+	NewUsersToday     int64 `json:"new_users_today"`
+	NewUsersThisWeek  int64 `json:"new_users_this_week"`
+	Messages          int64 `json:"messages"`
+	MessagesToday     int64 `json:"messages_today"`
+	OpenReports       int64 `json:"open_reports"`
+	ActiveSuspensions int64 `json:"active_suspensions"`
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// AcceptSchedule opens an inbox only at certain times. Windows repeat every
+// week in Timezone, MaxMessages and CloseAt close it for good once reached.
+// No windows means open whenever the other limits allow.
+type AcceptSchedule struct {
+	Timezone      string           `json:"timezone" bson:"timezone"`
+	Windows       []ScheduleWindow `json:"windows" bson:"windows,omitempty"`
+	MaxMessages   int              `json:"max_messages,omitempty" bson:"max_messages,omitempty"`
+	CloseAt       *time.Time       `json:"close_at,omitempty" bson:"close_at,omitempty"`
+	ReceivedCount int              `json:"received_count" bson:"received_count"`
+}
+
+// ScheduleWindow is open from Start to End ("HH:MM") on each of Days, where
+// 0 is Sunday. An End before Start runs past midnight.
+type ScheduleWindow struct {
+	Days  []int  `json:"days" bson:"days"`
+	Start string `json:"start" bson:"start"`
+	End   string `json:"end" bson:"end"`
+}
+
+// IsOpen reports whether the schedule accepts a message at now and, when it
+// does not, the next time it will. A nil next time means it stays closed.
+func (s AcceptSchedule) IsOpen(now time.Time) (bool, *time.Time) {
+	if s.CloseAt != nil && !now.Before(*s.CloseAt) {
+		return false, nil
+	}
+	if s.MaxMessages > 0 && s.ReceivedCount >= s.MaxMessages {
+		return false, nil
+	}
+	if len(s.Windows) == 0 {
+		return true, nil
+	}
+
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	local := now.In(loc)
+
+	var next *time.Time
+	// yesterday is included for windows that run past midnight
+	for offset := -1; offset <= 7; offset++ {
+		day := time.Date(local.Year(), local.Month(), local.Day()+offset, 0, 0, 0, 0, loc)
+		for _, window := range s.Windows {
+			if !window.onDay(day.Weekday()) {
+				continue
+			}
+			start, end, err := window.span(day)
+			if err != nil {
+				continue
+			}
+			if !now.Before(start) && now.Before(end) {
+				return true, nil
+			}
+			if start.After(now) && (next == nil || start.Before(*next)) {
+				opens := start
+				next = &opens
+			}
+		}
+	}
+
+	if next != nil && s.CloseAt != nil && !next.Before(*s.CloseAt) {
+		return false, nil
+	}
+	return false, next
+}
+
+func (w ScheduleWindow) onDay(weekday time.Weekday) bool {
+	for _, day := range w.Days {
+		if time.Weekday(day) == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+func (w ScheduleWindow) span(day time.Time) (time.Time, time.Time, error) {
+	startMinutes, err := clockMinutes(w.Start)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	endMinutes, err := clockMinutes(w.End)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if endMinutes <= startMinutes {
+		endMinutes += 24 * 60
+	}
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, startMinutes, 0, 0, day.Location())
+	end := time.Date(day.Year(), day.Month(), day.Day(), 0, endMinutes, 0, 0, day.Location())
+	return start, end, nil
+}
+
+func clockMinutes(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
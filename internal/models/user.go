@@ -15,7 +15,58 @@ type UserModel struct {
 	IsAcceptingMessages bool               `json:"is_accepting_messages,omitempty" bson:"is_accepting_messages,omitempty"`
 	VerifyCode          int                `json:"verify_code,omitempty" bson:"verify_code,omitempty"`
 	VerifyCodeExpiry    time.Time          `json:"verify_code_expiry,omitempty" bson:"verify_code_expiry,omitempty"`
+	VerifyAttempts      int                `json:"-" bson:"verify_attempts,omitempty"`
+	// Messages is only read to migrate inboxes stored before messages got their own collection
 	Messages            []Message          `json:"messages,omitempty" bson:"messages,omitempty"`
+	MFAEnabled          bool               `json:"mfa_enabled,omitempty" bson:"mfa_enabled,omitempty"`
+	MFASecret           string             `json:"-" bson:"mfa_secret,omitempty"`
+	MFAPendingSecret    string             `json:"-" bson:"mfa_pending_secret,omitempty"`
+	MFALastStep         int64              `json:"-" bson:"mfa_last_step,omitempty"`
+	RecoveryCodes       []string           `json:"-" bson:"recovery_codes,omitempty"`
+	MagicLinkID         string             `json:"-" bson:"magic_link_id,omitempty"`
+	Identities          []ExternalIdentity `json:"-" bson:"identities,omitempty"`
+	PendingEmail        string             `json:"pending_email,omitempty" bson:"pending_email,omitempty"`
+	PreviousUsernames   []UsernameAlias    `json:"-" bson:"previous_usernames,omitempty"`
+	DeletionScheduledAt time.Time          `json:"deletion_scheduled_at,omitempty" bson:"deletion_scheduled_at,omitempty"`
+	Retention           RetentionPolicy    `json:"retention" bson:"retention,omitempty"`
+	Schedule            *AcceptSchedule    `json:"schedule,omitempty" bson:"schedule,omitempty"`
+	Prompts             []Prompt           `json:"prompts,omitempty" bson:"prompts,omitempty"`
+	Profile             UserProfile        `json:"profile" bson:"profile,omitempty"`
+	Role                string             `json:"-" bson:"role,omitempty"`
+}
+
+// RetentionPolicy makes an inbox ephemeral. Messages are deleted AfterDays
+// after they arrive and, with AfterRead, shortly after they are read. A message
+// counts as read once the mark_read bulk action stamped its read_at, messages
+// without it never expire through AfterRead. The zero value keeps messages
+// until the user deletes them.
+type RetentionPolicy struct {
+	AfterDays int  `json:"after_days" bson:"after_days,omitempty"`
+	AfterRead bool `json:"after_read" bson:"after_read,omitempty"`
+}
+
+// ExpiresAt is when a message received at receivedAt is deleted, nil if never.
+func (p RetentionPolicy) ExpiresAt(receivedAt time.Time) *time.Time {
+	if p.AfterDays <= 0 {
+		return nil
+	}
+	expiresAt := receivedAt.AddDate(0, 0, p.AfterDays)
+	return &expiresAt
+}
+
+// UsernameAlias keeps an old username pointing at the user after a rename so
+// shared links keep working until Until.
+type UsernameAlias struct {
+	Username string    `json:"username" bson:"username"`
+	Until    time.Time `json:"until" bson:"until"`
+}
+
+// ExternalIdentity links an account at an OIDC provider to the user.
+type ExternalIdentity struct {
+	Provider string    `json:"provider" bson:"provider"`
+	Subject  string    `json:"subject" bson:"subject"`
+	Email    string    `json:"email,omitempty" bson:"email,omitempty"`
+	LinkedAt time.Time `json:"linked_at" bson:"linked_at"`
 }
 
 type SingInModel struct {
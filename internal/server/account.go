@@ -0,0 +1,381 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"silent-notes/internal/database"
+	"silent-notes/internal/models"
+	"silent-notes/internal/types"
+	"silent-notes/internal/utils"
+	"silent-notes/internal/utils/email"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (s *Server) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	var passwordData types.ChangePasswordType
+	err = json.NewDecoder(r.Body).Decode(&passwordData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid input", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer r.Body.Close()
+
+	var validate = validator.New()
+	err = validate.Struct(passwordData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "validation failed", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	user := s.db.GetUserByID(userIdObjectId)
+	if user == nil {
+		w.WriteHeader(http.StatusNotFound)
+		res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "user not found"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if wait := s.retryAfter(accountThrottleKey(user)); wait > 0 {
+		writeTooManyAttempts(w, wait)
+		return
+	}
+
+	// accounts created through an OIDC provider have no password to confirm yet
+	if user.Password != "" && !utils.CheckPassword(passwordData.CurrentPassword, user.Password) {
+		s.recordSignInFailure(r, user)
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "wrong credentials", Error: "wrong credentials"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	hashedPassword, err := utils.HashPassword(passwordData.NewPassword)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	err = s.db.UpdatePassword(userIdObjectId, string(hashedPassword))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error changing password", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	s.audit(r, userIdObjectId, models.AuditPasswordChanged, nil)
+
+	// whoever knew the old password is signed out everywhere else
+	current, _ := primitive.ObjectIDFromHex(r.Context().Value(types.SessionIDKey).(string))
+	if _, err := s.db.DeleteSessions(userIdObjectId, current); err != nil {
+		log.Printf("error revoking sessions after password change: %v", err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "password changed successfully"}
+	json.NewEncoder(w).Encode(res)
+}
+
+// ChangeEmail mails a verify code to the new address. The change only takes
+// effect once that code is submitted to VerifyUser.
+func (s *Server) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	var emailData types.ChangeEmailType
+	err = json.NewDecoder(r.Body).Decode(&emailData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid input", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer r.Body.Close()
+
+	var validate = validator.New()
+	err = validate.Struct(emailData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "validation failed", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	user := s.db.GetUserByID(userIdObjectId)
+	if user == nil {
+		w.WriteHeader(http.StatusNotFound)
+		res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "user not found"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if wait := s.retryAfter(accountThrottleKey(user)); wait > 0 {
+		writeTooManyAttempts(w, wait)
+		return
+	}
+
+	if (user.Password != "" && !utils.CheckPassword(emailData.Password, user.Password)) ||
+		(user.MFAEnabled && !s.checkSecondFactor(user, emailData.Code, "")) {
+		s.recordSignInFailure(r, user)
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "wrong credentials", Error: "wrong credentials"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if s.db.CheckExistingUser("", emailData.Email) {
+		w.WriteHeader(http.StatusConflict)
+		res := types.Response{StatusCode: http.StatusConflict, Success: false, Message: "email already taken"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	verifyCode := utils.GenerateVerifyCode()
+	err = s.db.SetPendingEmail(userIdObjectId, emailData.Email, verifyCode, utils.VerifyCodeExpiry())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	err = email.SendVerificationEmail(user.Username, emailData.Email, verifyCode)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error sending email verification code", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	s.audit(r, userIdObjectId, models.AuditEmailChangeRequested, map[string]string{"email": emailData.Email})
+
+	// the current address hears about it too, in case the session was stolen
+	go func() {
+		if err := email.SendEmailChangeRequestedEmail(user.Username, user.Email, emailData.Email); err != nil {
+			log.Printf("error sending email change notice: %v", err)
+		}
+	}()
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "verification code sent to the new email"}
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) ChangeUsername(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	var usernameData types.ChangeUsernameType
+	err = json.NewDecoder(r.Body).Decode(&usernameData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid input", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer r.Body.Close()
+
+	var validate = validator.New()
+	err = validate.Struct(usernameData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "validation failed", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	newUsername := strings.TrimSpace(usernameData.Username)
+	if !usernamePattern.MatchString(newUsername) {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "username can only contain lowercase letters, digits and underscores"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	if utils.IsReservedUsername(newUsername) {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "username is reserved"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	user := s.db.GetUserByID(userIdObjectId)
+	if user == nil {
+		w.WriteHeader(http.StatusNotFound)
+		res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "user not found"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if user.Username == newUsername {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "that is already your username"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if s.db.UsernameTaken(newUsername, userIdObjectId) {
+		w.WriteHeader(http.StatusConflict)
+		res := types.Response{StatusCode: http.StatusConflict, Success: false, Message: "username already taken"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	aliasUntil := time.Now().Add(utils.UsernameGracePeriod())
+	err = s.db.ChangeUsername(userIdObjectId, user.Username, newUsername, aliasUntil)
+	if errors.Is(err, database.ErrUsernameTaken) {
+		w.WriteHeader(http.StatusConflict)
+		res := types.Response{StatusCode: http.StatusConflict, Success: false, Message: "username already taken"}
+		json.NewEncoder(w).Encode(res)
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error changing username", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	s.audit(r, userIdObjectId, models.AuditUsernameChanged, map[string]string{"from": user.Username, "to": newUsername})
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "username changed successfully", Data: map[string]interface{}{
+		"username":           newUsername,
+		"old_username_until": aliasUntil,
+	}}
+	json.NewEncoder(w).Encode(res)
+}
+
+// ResolveUsername lets share links built on an old username find the user's
+// current one during the rename grace period.
+func (s *Server) ResolveUsername(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+
+	user := s.db.GetUser(username, "password")
+	if user == nil || user.Email == username {
+		w.WriteHeader(http.StatusNotFound)
+		res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "user not found"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	moved := user.Username != username
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "username resolved", Data: map[string]interface{}{
+		"username": user.Username,
+		"moved":    moved,
+	}}
+	json.NewEncoder(w).Encode(res)
+}
+
+// DeleteAccount schedules the account for deletion after the grace period and
+// signs the user out. Signing in again before then cancels it.
+func (s *Server) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	var deleteData types.DeleteAccountType
+	err = json.NewDecoder(r.Body).Decode(&deleteData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid input", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer r.Body.Close()
+
+	user := s.db.GetUserByID(userIdObjectId)
+	if user == nil {
+		w.WriteHeader(http.StatusNotFound)
+		res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "user not found"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if wait := s.retryAfter(accountThrottleKey(user)); wait > 0 {
+		writeTooManyAttempts(w, wait)
+		return
+	}
+
+	if user.Password == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "set a password before deleting your account"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if !utils.CheckPassword(deleteData.Password, user.Password) || (user.MFAEnabled && !s.checkSecondFactor(user, deleteData.Code, "")) {
+		s.recordSignInFailure(r, user)
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "wrong credentials", Error: "wrong credentials"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	deleteAt := time.Now().Add(utils.AccountDeletionGrace())
+	err = s.db.ScheduleAccountDeletion(userIdObjectId, deleteAt)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error scheduling account deletion", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	s.audit(r, userIdObjectId, models.AuditDeletionScheduled, map[string]string{"delete_at": deleteAt.UTC().Format(time.RFC3339)})
+	if _, err := s.db.DeleteSessions(userIdObjectId, primitive.NilObjectID); err != nil {
+		log.Printf("error revoking sessions of deleted account: %v", err)
+	}
+
+	go func() {
+		if err := email.SendDeletionScheduledEmail(user.Username, user.Email, deleteAt); err != nil {
+			log.Printf("error sending deletion scheduled email: %v", err)
+		}
+	}()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "token",
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteLaxMode,
+	})
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "account scheduled for deletion, sign in before then to cancel", Data: map[string]interface{}{"deletion_scheduled_at": deleteAt}}
+	json.NewEncoder(w).Encode(res)
+}
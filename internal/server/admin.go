@@ -0,0 +1,289 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"silent-notes/internal/database"
+	"silent-notes/internal/models"
+	"silent-notes/internal/types"
+	"silent-notes/internal/utils"
+	"silent-notes/internal/utils/email"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultAdminUserLimit = 50
+	maxAdminUserLimit     = 200
+)
+
+// bootstrapAdmins makes the accounts listed in ADMIN_USER_IDS admins, it is
+// how the first admin gets in before anyone can hand out roles.
+func (s *Server) bootstrapAdmins() {
+	for _, id := range strings.Split(os.Getenv("ADMIN_USER_IDS"), ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		userId, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			log.Printf("ignoring invalid id %q in ADMIN_USER_IDS", id)
+			continue
+		}
+		if err := s.db.SetUserRole(userId, models.RoleAdmin); err != nil {
+			log.Printf("error making %s an admin: %v", id, err)
+		}
+	}
+}
+
+// ListUsers pages through accounts, ?q= matches the start of a username or
+// email and ?role= picks one role.
+func (s *Server) ListUsers(w http.ResponseWriter, r *http.Request) {
+	search := strings.TrimSpace(r.URL.Query().Get("q"))
+	role := r.URL.Query().Get("role")
+	if role != "" && !models.IsRole(role) {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "role must be user, moderator or admin"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	limit, page, err := parsePage(r, defaultAdminUserLimit, maxAdminUserLimit)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid paging", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	users, err := s.db.SearchUsers(search, role, int64(limit), int64((page-1)*limit))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	summaries := make([]models.UserSummary, 0, len(users))
+	for i := range users {
+		summaries = append(summaries, users[i].Summary())
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "users", Data: map[string]interface{}{
+		"users": summaries,
+		"page":  page,
+		"limit": limit,
+	}}
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) GetUserAdmin(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.loadAdminUser(w, r)
+	if !ok {
+		return
+	}
+
+	suspended, err := s.db.IsUserSuspended(user.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "user", Data: map[string]interface{}{
+		"user":      user.Summary(),
+		"suspended": suspended,
+	}}
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) GetStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.db.GetStats(time.Now())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "stats", Data: map[string]interface{}{"stats": stats}}
+	json.NewEncoder(w).Encode(res)
+}
+
+// SetUserRole changes an account's role. Admins cannot change their own so
+// the last admin cannot lock everyone out by accident.
+func (s *Server) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	adminId, err := primitive.ObjectIDFromHex(r.Context().Value(types.UserIDKey).(string))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	var roleData types.RoleType
+	err = json.NewDecoder(r.Body).Decode(&roleData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid input", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer r.Body.Close()
+
+	var validate = validator.New()
+	err = validate.Struct(roleData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "validation failed", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	user, ok := s.loadAdminUser(w, r)
+	if !ok {
+		return
+	}
+	if user.ID == adminId {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "you cannot change your own role"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	err = s.db.SetUserRole(user.ID, roleData.Role)
+	if err != nil {
+		status, message := http.StatusInternalServerError, "internal server error"
+		if errors.Is(err, database.ErrUserNotFound) {
+			status, message = http.StatusNotFound, "user not found"
+		}
+		w.WriteHeader(status)
+		res := types.Response{StatusCode: status, Success: false, Message: message, Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	s.logModeration(r, adminId, "role."+roleData.Role, primitive.NilObjectID, user.ID, "was "+user.EffectiveRole())
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "role updated", Data: map[string]interface{}{"role": roleData.Role}}
+	json.NewEncoder(w).Encode(res)
+}
+
+// SuspendUser suspends an account from the user view, the same as creating
+// a user suspension.
+func (s *Server) SuspendUser(w http.ResponseWriter, r *http.Request) {
+	moderatorId, err := primitive.ObjectIDFromHex(r.Context().Value(types.UserIDKey).(string))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	var suspendData types.UserSuspensionType
+	err = json.NewDecoder(r.Body).Decode(&suspendData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid input", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer r.Body.Close()
+
+	var validate = validator.New()
+	err = validate.Struct(suspendData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "validation failed", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	userId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "userId"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid user id", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	s.createSuspension(w, r, moderatorId, types.SuspensionType{
+		Kind:   models.SuspendUser,
+		UserID: userId.Hex(),
+		Reason: suspendData.Reason,
+		Days:   suspendData.Days,
+	})
+}
+
+// ResendVerification mails a fresh verification code to an unverified account.
+func (s *Server) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	adminId, err := primitive.ObjectIDFromHex(r.Context().Value(types.UserIDKey).(string))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	user, ok := s.loadAdminUser(w, r)
+	if !ok {
+		return
+	}
+	if user.IsVerified {
+		w.WriteHeader(http.StatusConflict)
+		res := types.Response{StatusCode: http.StatusConflict, Success: false, Message: "user is already verified"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	verifyCode := utils.GenerateVerifyCode()
+	if _, err := s.db.ReVerifyCode(user.ID, verifyCode, utils.VerifyCodeExpiry()); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	if err := email.SendVerificationEmail(user.Username, user.Email, verifyCode); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error sending email verification code", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	s.logModeration(r, adminId, "user.resend_verification", primitive.NilObjectID, user.ID, "")
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "verification email sent"}
+	json.NewEncoder(w).Encode(res)
+}
+
+// loadAdminUser reads the {userId} route parameter and writes the error
+// response itself when the account cannot be loaded.
+func (s *Server) loadAdminUser(w http.ResponseWriter, r *http.Request) (*models.UserModel, bool) {
+	userId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "userId"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid user id", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return nil, false
+	}
+
+	user := s.db.GetUserByID(userId)
+	if user == nil {
+		w.WriteHeader(http.StatusNotFound)
+		res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "user not found"}
+		json.NewEncoder(w).Encode(res)
+		return nil, false
+	}
+	return user, true
+}
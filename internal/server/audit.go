@@ -0,0 +1,118 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"silent-notes/internal/models"
+	"silent-notes/internal/types"
+	"silent-notes/internal/utils"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 200
+
+	maxUserAgent = 256
+)
+
+// audit records a security-relevant event on userId's account with the
+// caller's address, browser and request id. Like the moderation log the
+// event is written after the fact, so a failed write is only logged.
+func (s *Server) audit(r *http.Request, userId primitive.ObjectID, action string, details map[string]string) {
+	s.addAuditEvent(r, models.AuditEvent{UserID: userId, Action: action, Details: details})
+}
+
+func (s *Server) addAuditEvent(r *http.Request, event models.AuditEvent) {
+	event.ID = primitive.NewObjectID()
+	event.IP = utils.ClientIP(r)
+	event.UserAgent = userAgent(r)
+	event.RequestID = middleware.GetReqID(r.Context())
+	event.CreatedAt = time.Now()
+	if err := s.db.AddAuditEvent(event); err != nil {
+		log.Printf("error recording audit event %s for %s: %v", event.Action, event.UserID.Hex(), err)
+	}
+}
+
+// userAgent is the caller's user agent cut down to a size worth storing.
+func userAgent(r *http.Request) string {
+	ua := r.UserAgent()
+	if len(ua) > maxUserAgent {
+		ua = ua[:maxUserAgent]
+		for !utf8.ValidString(ua) {
+			ua = ua[:len(ua)-1]
+		}
+	}
+	return ua
+}
+
+// GetAuditLog shows the user what happened on their own account, ?action=
+// picks one action or, ending in ".", a group like "auth.".
+func (s *Server) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	s.writeAuditEvents(w, r, models.AuditFilter{
+		UserID: userIdObjectId,
+		Action: strings.TrimSpace(r.URL.Query().Get("action")),
+	})
+}
+
+// GetAuditEvents is the admin view of the trail, ?user_id= also includes
+// admin actions taken on that account.
+func (s *Server) GetAuditEvents(w http.ResponseWriter, r *http.Request) {
+	filter := models.AuditFilter{
+		Action:       strings.TrimSpace(r.URL.Query().Get("action")),
+		WithTargeted: true,
+	}
+	if value := r.URL.Query().Get("user_id"); value != "" {
+		userId, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid user id", Error: err.Error()}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		filter.UserID = userId
+	}
+
+	s.writeAuditEvents(w, r, filter)
+}
+
+func (s *Server) writeAuditEvents(w http.ResponseWriter, r *http.Request, filter models.AuditFilter) {
+	limit, page, err := parsePage(r, defaultAuditLimit, maxAuditLimit)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid paging", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	events, err := s.db.GetAuditEvents(filter, int64(limit), int64((page-1)*limit))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "audit log", Data: map[string]interface{}{
+		"events": events,
+		"page":   page,
+		"limit":  limit,
+	}}
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"silent-notes/internal/storage"
+	"silent-notes/internal/types"
+	"silent-notes/internal/utils"
+	"silent-notes/internal/utils/imaging"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const maxAvatarBytes = 5 << 20
+
+// every avatar is stored in these square sizes, the middle one is the default
+var avatarSizes = []int{64, 256, 512}
+
+const defaultAvatarSize = 256
+
+var avatarFilePattern = regexp.MustCompile(`^[0-9]+\.(png|webp)$`)
+
+// UploadAvatar takes a multipart "avatar" file, checks what it really is,
+// re-encodes it without metadata in every size as PNG and WebP and swaps it in
+// for the current avatar.
+func (s *Server) UploadAvatar(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	// leave room for the multipart framing around the file
+	r.Body = http.MaxBytesReader(w, r.Body, maxAvatarBytes+64<<10)
+	if err := r.ParseMultipartForm(maxAvatarBytes); err != nil {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		res := types.Response{StatusCode: http.StatusRequestEntityTooLarge, Success: false, Message: fmt.Sprintf("avatar must be a multipart upload of at most %d MB", maxAvatarBytes>>20), Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "avatar file is missing", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxAvatarBytes+1))
+	if err != nil || len(data) > maxAvatarBytes {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		res := types.Response{StatusCode: http.StatusRequestEntityTooLarge, Success: false, Message: fmt.Sprintf("avatar must be at most %d MB", maxAvatarBytes>>20)}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if _, err := imaging.Sniff(data); err != nil {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		res := types.Response{StatusCode: http.StatusUnsupportedMediaType, Success: false, Message: "avatar must be a PNG, JPEG, GIF or WebP image", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	img, err := imaging.Decode(data)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "avatar could not be read", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	user := s.db.GetUserByID(userIdObjectId)
+	if user == nil {
+		w.WriteHeader(http.StatusNotFound)
+		res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "user not found"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	// a new version for every upload so cached copies of the old one never linger
+	version, err := utils.RandomToken(8)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	urls := map[string]string{}
+	var keys []string
+	for _, size := range avatarSizes {
+		square := imaging.Square(img, size)
+		for _, format := range []string{"png", "webp"} {
+			var encoded []byte
+			if format == "png" {
+				encoded, err = imaging.EncodePNG(square)
+			} else {
+				encoded, err = imaging.EncodeWebP(square)
+			}
+			if err == nil {
+				name := strconv.Itoa(size) + "." + format
+				key := avatarKey(userId, version, name)
+				err = s.store.Put(r.Context(), key, "image/"+format, encoded)
+				keys = append(keys, key)
+				urls[name] = avatarURL(userId, version, name)
+			}
+			if err != nil {
+				s.removeBlobs(keys)
+				w.WriteHeader(http.StatusInternalServerError)
+				res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error storing avatar", Error: err.Error()}
+				json.NewEncoder(w).Encode(res)
+				return
+			}
+		}
+	}
+
+	avatar := urls[strconv.Itoa(defaultAvatarSize)+".png"]
+	if err := s.db.SetAvatar(userIdObjectId, avatar, urls, keys); err != nil {
+		s.removeBlobs(keys)
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error saving avatar", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	s.removeBlobs(user.Profile.AvatarKeys)
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "avatar updated", Data: map[string]interface{}{
+		"avatar":      avatar,
+		"avatar_urls": urls,
+	}}
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) DeleteAvatar(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	user := s.db.GetUserByID(userIdObjectId)
+	if user == nil {
+		w.WriteHeader(http.StatusNotFound)
+		res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "user not found"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if err := s.db.SetAvatar(userIdObjectId, "", nil, nil); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error removing avatar", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	s.removeBlobs(user.Profile.AvatarKeys)
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "avatar removed"}
+	json.NewEncoder(w).Encode(res)
+}
+
+// ServeAvatar streams a stored avatar. The URL changes with every upload so
+// it can be cached for good.
+func (s *Server) ServeAvatar(w http.ResponseWriter, r *http.Request) {
+	userId, version, file := chi.URLParam(r, "userId"), chi.URLParam(r, "version"), chi.URLParam(r, "file")
+	if !primitive.IsValidObjectID(userId) || !avatarFilePattern.MatchString(file) {
+		w.WriteHeader(http.StatusNotFound)
+		res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "avatar not found"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	blob, contentType, err := s.store.Get(r.Context(), avatarKey(userId, version, file))
+	if err != nil {
+		status, message := http.StatusInternalServerError, "internal server error"
+		if errors.Is(err, storage.ErrNotFound) {
+			status, message = http.StatusNotFound, "avatar not found"
+		}
+		w.WriteHeader(status)
+		res := types.Response{StatusCode: status, Success: false, Message: message}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, blob)
+}
+
+func avatarKey(userId, version, name string) string {
+	return "avatars/" + userId + "/" + version + "/" + name
+}
+
+func avatarURL(userId, version, name string) string {
+	return utils.APIURL() + "/api/v1/avatars/" + userId + "/" + version + "/" + name
+}
+
+// removeBlobs deletes blobs that are no longer referenced, failures only
+// leave garbage behind so they are logged.
+func (s *Server) removeBlobs(keys []string) {
+	for _, key := range keys {
+		if err := s.store.Delete(context.Background(), key); err != nil {
+			log.Printf("error removing blob %s: %v", key, err)
+		}
+	}
+}
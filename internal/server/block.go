@@ -0,0 +1,149 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"silent-notes/internal/database"
+	"silent-notes/internal/models"
+	"silent-notes/internal/types"
+	"silent-notes/internal/utils"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BlockSender blocks whoever sent a message. Their future messages and
+// follow-ups are dropped without telling them. The block matches the sender's
+// network and browser, not a person, and lapses once the fingerprint salt has
+// rotated out.
+func (s *Server) BlockSender(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	messageId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "mId"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid message id", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	message, err := s.db.GetMessage(userIdObjectId, messageId)
+	if err != nil {
+		if errors.Is(err, database.ErrMessageNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "message not found", Error: err.Error()}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	now := time.Now()
+	epoch, ok := utils.ParseFingerprintEpoch(message.Fingerprint)
+	if !ok || !utils.FingerprintExpiry(epoch).After(now) {
+		w.WriteHeader(http.StatusGone)
+		res := types.Response{StatusCode: http.StatusGone, Success: false, Message: "this message is too old to block its sender"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	block, err := s.db.BlockSender(models.SenderBlock{
+		ID:          primitive.NewObjectID(),
+		UserID:      userIdObjectId,
+		MessageID:   messageId,
+		Fingerprint: message.Fingerprint,
+		CreatedAt:   now,
+		ExpiresAt:   utils.FingerprintExpiry(epoch),
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "sender blocked", Data: map[string]interface{}{"block": block}}
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) GetBlocks(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	blocks, err := s.db.GetSenderBlocks(userIdObjectId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "blocked senders", Data: map[string]interface{}{"blocks": blocks}}
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) UnblockSender(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	blockId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "blockId"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid block id", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	err = s.db.DeleteSenderBlock(userIdObjectId, blockId)
+	if err != nil {
+		if errors.Is(err, database.ErrBlockNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "block not found", Error: err.Error()}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "sender unblocked"}
+	json.NewEncoder(w).Encode(res)
+}
+
+// clearFingerprints drops fingerprints that can no longer match a sender, so
+// messages only carry one while it is useful for blocking.
+func (s *Server) clearFingerprints() error {
+	// messages from before the previous epoch only hold expired fingerprints
+	before := utils.FingerprintExpiry(utils.FingerprintEpoch(time.Now()) - 3)
+	_, err := s.db.ClearFingerprints(before)
+	return err
+}
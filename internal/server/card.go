@@ -0,0 +1,164 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"silent-notes/internal/database"
+	"silent-notes/internal/storage"
+	"silent-notes/internal/types"
+	"silent-notes/internal/utils"
+	"silent-notes/internal/utils/cards"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const maxCardAnswer = 300
+
+// MessageCard renders a message and an optional answer as a PNG to share on
+// social stories. ?theme= and ?aspect= pick the look, ?answer= overrides the
+// saved reply. Cards showing the saved reply are kept in the blob store so
+// reposting one is cheap, a one-off ?answer= or a message that is going to
+// expire is rendered every time.
+func (s *Server) MessageCard(w http.ResponseWriter, r *http.Request) {
+	uId := r.Context().Value(types.UserIDKey).(string)
+	userId, err := primitive.ObjectIDFromHex(uId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	messageId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "mId"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid message id", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	query := r.URL.Query()
+	theme := query.Get("theme")
+	if theme == "" {
+		theme = cards.DefaultTheme
+	}
+	aspect := query.Get("aspect")
+	if aspect == "" {
+		aspect = "story"
+	}
+	answer := strings.TrimSpace(query.Get("answer"))
+	if _, ok := cards.Themes[theme]; !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "theme must be midnight, sunset or paper"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	if _, ok := cards.Aspects[aspect]; !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "aspect must be story or square"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	if utf8.RuneCountInString(answer) > maxCardAnswer {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "answer must be at most 300 characters"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	message, err := s.db.GetMessage(userId, messageId)
+	if err != nil {
+		if errors.Is(err, database.ErrMessageNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "message not found", Error: err.Error()}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	user := s.db.GetUserByID(userId)
+	if user == nil {
+		w.WriteHeader(http.StatusNotFound)
+		res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "user not found"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	// without an explicit answer the card shows the reply the sender can see
+	cache := answer == "" && message.ExpiresAt == nil
+	if answer == "" && message.Reply != nil {
+		answer = message.Reply.Content
+	}
+
+	card := cards.Card{
+		Question: message.Content,
+		Answer:   answer,
+		Footer:   strings.TrimPrefix(strings.TrimPrefix(utils.ProfileURL(user.Username), "https://"), "http://"),
+		Theme:    theme,
+		Aspect:   aspect,
+	}
+	key := cardKey(uId, messageId.Hex(), card)
+
+	if cache {
+		if blob, _, err := s.store.Get(r.Context(), key); err == nil {
+			defer blob.Close()
+			writeCard(w, blob)
+			return
+		} else if !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("error reading cached card %s: %v", key, err)
+		}
+	}
+
+	rendered, err := cards.Render(card)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error rendering card", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	// a failed cache write only means the next request renders again
+	if cache {
+		if err := s.store.Put(r.Context(), key, "image/png", rendered); err != nil {
+			log.Printf("error caching card %s: %v", key, err)
+		}
+	}
+	writeCard(w, bytes.NewReader(rendered))
+}
+
+// removeCards drops the cached cards of messages that are deleted for good.
+func (s *Server) removeCards(userId primitive.ObjectID, messageIds []primitive.ObjectID) {
+	for _, messageId := range messageIds {
+		if err := s.store.DeletePrefix(context.Background(), "cards/"+userId.Hex()+"/"+messageId.Hex()+"/"); err != nil {
+			log.Printf("error removing cards of message %s: %v", messageId.Hex(), err)
+		}
+	}
+}
+
+// cardKey changes whenever anything printed on the card does, so a new
+// answer or username never serves a stale image.
+func cardKey(userId, messageId string, card cards.Card) string {
+	sum := sha256.Sum256([]byte(card.Answer + "\x00" + card.Footer))
+	return "cards/" + userId + "/" + messageId + "/" + card.Theme + "-" + card.Aspect + "-" + hex.EncodeToString(sum[:8]) + ".png"
+}
+
+func writeCard(w http.ResponseWriter, card io.Reader) {
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, card)
+}
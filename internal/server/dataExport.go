@@ -0,0 +1,307 @@
+package server
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"silent-notes/internal/models"
+	"silent-notes/internal/types"
+	"silent-notes/internal/utils"
+	"silent-notes/internal/utils/email"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// an export still processing after this was left behind by a crash
+	exportClaimTimeout = 30 * time.Minute
+	// exports that keep taking the process down are given up on
+	maxExportAttempts = 3
+)
+
+// RequestDataExport queues a copy of everything stored about the user. The
+// archive is built in the background and a download link is emailed.
+func (s *Server) RequestDataExport(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer r.Body.Close()
+
+	if open := s.db.GetOpenDataExport(userIdObjectId); open != nil {
+		w.WriteHeader(http.StatusAccepted)
+		res := types.Response{StatusCode: http.StatusAccepted, Success: true, Message: "an export is already being prepared", Data: map[string]interface{}{"export": open}}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	export := models.DataExport{
+		ID:        primitive.NewObjectID(),
+		UserID:    userIdObjectId,
+		Status:    models.ExportPending,
+		CreatedAt: time.Now(),
+	}
+	err = s.db.CreateDataExport(export)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error requesting export", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	res := types.Response{StatusCode: http.StatusAccepted, Success: true, Message: "export requested, we will email you a download link", Data: map[string]interface{}{"export": export}}
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) GetDataExport(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	exportId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "exportId"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid export id", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	export := s.db.GetDataExport(exportId, userIdObjectId)
+	if export == nil {
+		w.WriteHeader(http.StatusNotFound)
+		res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "export not found"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "export status", Data: map[string]interface{}{"export": export}}
+	json.NewEncoder(w).Encode(res)
+}
+
+// DownloadDataExport serves the archive to whoever holds the signed link from
+// the email, no session is needed.
+func (s *Server) DownloadDataExport(w http.ResponseWriter, r *http.Request) {
+	claims, err := utils.VerifyPurposeJWT(r.URL.Query().Get("token"), utils.PurposeExport)
+	if err != nil || claims["export_id"] != chi.URLParam(r, "exportId") {
+		w.WriteHeader(http.StatusUnauthorized)
+		res := types.Response{StatusCode: http.StatusUnauthorized, Success: false, Message: "download link is invalid or expired"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	userIdObjectId, err := primitive.ObjectIDFromHex(claims["user_id"].(string))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	exportId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "exportId"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid export id", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	export := s.db.GetDataExport(exportId, userIdObjectId)
+	if export == nil || export.Status != models.ExportReady || time.Now().After(export.ExpiresAt) {
+		w.WriteHeader(http.StatusNotFound)
+		res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "export not found"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	file, err := os.Open(export.File)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "export not found"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="silent-notes-export-%s.zip"`, export.CreatedAt.Format("2006-01-02")))
+	w.Header().Set("Cache-Control", "no-store")
+	http.ServeContent(w, r, "", export.CompletedAt, file)
+}
+
+// processDataExports builds every queued export.
+func (s *Server) processDataExports() error {
+	for {
+		export, err := s.db.ClaimDataExport(time.Now().Add(-exportClaimTimeout))
+		if err != nil {
+			return err
+		}
+		if export == nil {
+			return nil
+		}
+		if export.Attempts > maxExportAttempts {
+			s.db.FailDataExport(export.ID, "export could not be built")
+			continue
+		}
+
+		user := s.db.GetUserByID(export.UserID)
+		if user == nil {
+			s.db.FailDataExport(export.ID, "user not found")
+			continue
+		}
+
+		file, err := s.buildDataExport(user, export)
+		if err != nil {
+			log.Printf("error building export %s: %v", export.ID.Hex(), err)
+			s.db.FailDataExport(export.ID, err.Error())
+			continue
+		}
+
+		expiresAt := time.Now().Add(utils.ExportLinkTTL())
+		if err := s.db.CompleteDataExport(export.ID, file, expiresAt); err != nil {
+			os.Remove(file)
+			return err
+		}
+
+		token, err := utils.CreateExportToken(user.ID.Hex(), export.ID.Hex(), expiresAt)
+		if err != nil {
+			return err
+		}
+		link := fmt.Sprintf("%s/api/v1/exports/%s/download?token=%s", utils.APIURL(), export.ID.Hex(), url.QueryEscape(token))
+		if err := email.SendExportReadyEmail(user.Username, user.Email, link, expiresAt); err != nil {
+			log.Printf("error sending export ready email: %v", err)
+		}
+	}
+}
+
+// buildDataExport writes the user's data as JSON files into a zip archive.
+func (s *Server) buildDataExport(user *models.UserModel, export *models.DataExport) (string, error) {
+	messages, err := s.db.GetMessages(user.ID, models.MessageFilter{})
+	if err != nil {
+		return "", err
+	}
+	if messages == nil {
+		messages = []models.Message{}
+	}
+	trash, err := s.db.GetTrashedMessages(user.ID)
+	if err != nil {
+		return "", err
+	}
+	audit, err := s.db.GetAuditEvents(models.AuditFilter{UserID: user.ID}, 0, 0)
+	if err != nil {
+		return "", err
+	}
+	sessions, err := s.db.GetSessions(user.ID)
+	if err != nil {
+		return "", err
+	}
+
+	identities := []map[string]interface{}{}
+	for _, identity := range user.Identities {
+		identities = append(identities, map[string]interface{}{
+			"provider":  identity.Provider,
+			"email":     identity.Email,
+			"linked_at": identity.LinkedAt,
+		})
+	}
+
+	files := map[string]interface{}{
+		"profile.json": map[string]interface{}{
+			"id":                    user.ID,
+			"username":              user.Username,
+			"email":                 user.Email,
+			"pending_email":         user.PendingEmail,
+			"is_verified":           user.IsVerified,
+			"previous_usernames":    user.PreviousUsernames,
+			"profile":               user.Profile,
+			"linked_identities":     identities,
+			"deletion_scheduled_at": user.DeletionScheduledAt,
+		},
+		"settings.json": map[string]interface{}{
+			"is_accepting_messages":  user.IsAcceptingMessages,
+			"mfa_enabled":            user.MFAEnabled,
+			"recovery_codes_left":    len(user.RecoveryCodes),
+			"has_password":           user.Password != "",
+			"magic_link_outstanding": user.MagicLinkID != "",
+			"retention":              user.Retention,
+			"schedule":               user.Schedule,
+			"prompts":                user.Prompts,
+		},
+		"messages.json": messages,
+		"trash.json":    trash,
+		"audit.json":    audit,
+		"sessions.json": sessions,
+	}
+
+	dir := filepath.Join(utils.ExportDir(), user.ID.Hex())
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, export.ID.Hex()+".zip")
+
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return "", err
+	}
+	archive := zip.NewWriter(out)
+
+	for name, content := range files {
+		entry, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+		if err == nil {
+			encoder := json.NewEncoder(entry)
+			encoder.SetIndent("", "  ")
+			err = encoder.Encode(content)
+		}
+		if err != nil {
+			archive.Close()
+			out.Close()
+			os.Remove(path)
+			return "", err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		out.Close()
+		os.Remove(path)
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// removeExpiredDataExports deletes archives whose download link has expired.
+func (s *Server) removeExpiredDataExports() error {
+	exports, err := s.db.GetExpiredDataExports(time.Now())
+	if err != nil {
+		return err
+	}
+	for _, export := range exports {
+		if export.File != "" {
+			if err := os.Remove(export.File); err != nil && !os.IsNotExist(err) {
+				log.Printf("error removing export file %s: %v", export.File, err)
+				continue
+			}
+		}
+		s.db.DeleteDataExport(export.ID)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"silent-notes/internal/utils"
+	"silent-notes/internal/utils/email"
+	"strings"
+	"time"
+)
+
+// runEvery runs job on a fixed interval for the lifetime of the process.
+func runEvery(name string, interval time.Duration, job func() error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := job(); err != nil {
+				log.Printf("job %s failed: %v", name, err)
+			}
+		}
+	}()
+}
+
+func (s *Server) startJobs() {
+	runEvery("rotate-signing-keys", time.Hour, func() error {
+		return utils.RotateSigningKeys(utils.KeyRotationInterval())
+	})
+	runEvery("purge-deleted-accounts", time.Hour, s.purgeDeletedAccounts)
+	runEvery("process-data-exports", time.Minute, s.processDataExports)
+	runEvery("remove-expired-data-exports", time.Hour, s.removeExpiredDataExports)
+	runEvery("purge-trashed-messages", time.Hour, s.purgeTrashedMessages)
+	runEvery("clear-fingerprints", time.Hour, s.clearFingerprints)
+}
+
+// purgeDeletedAccounts removes accounts whose deletion grace period is over.
+func (s *Server) purgeDeletedAccounts() error {
+	users, err := s.db.GetUsersDueForDeletion(time.Now())
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if err := s.db.PurgeUser(user.ID); err != nil {
+			log.Printf("error purging user %s: %v", user.ID.Hex(), err)
+			continue
+		}
+		for _, prefix := range []string{"avatars/", "cards/"} {
+			if err := s.store.DeletePrefix(context.Background(), prefix+user.ID.Hex()+"/"); err != nil {
+				log.Printf("error removing %s of user %s: %v", strings.TrimSuffix(prefix, "/"), user.ID.Hex(), err)
+			}
+		}
+		if err := os.RemoveAll(filepath.Join(utils.ExportDir(), user.ID.Hex())); err != nil {
+			log.Printf("error removing exports of user %s: %v", user.ID.Hex(), err)
+		}
+		if err := email.SendAccountDeletedEmail(user.Username, user.Email); err != nil {
+			log.Printf("error sending account deleted email: %v", err)
+		}
+	}
+	return nil
+}
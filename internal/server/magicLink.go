@@ -0,0 +1,166 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"silent-notes/internal/types"
+	"silent-notes/internal/utils"
+	"silent-notes/internal/utils/email"
+
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RequestMagicLink mails a single use sign-in link. The response is the same
+// whether or not the account exists so it can't be used to probe for users.
+func (s *Server) RequestMagicLink(w http.ResponseWriter, r *http.Request) {
+	var linkData types.MagicLinkRequestType
+	err := json.NewDecoder(r.Body).Decode(&linkData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid input", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer r.Body.Close()
+
+	var validate = validator.New()
+	err = validate.Struct(linkData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "validation failed", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	ipKey := magicLinkThrottleKey(ipThrottleKey(r))
+	if wait := max(s.retryAfter(ipThrottleKey(r)), s.retryAfter(ipKey)); wait > 0 {
+		writeTooManyAttempts(w, wait)
+		return
+	}
+	s.recordFailure(ipKey, magicLinkIPLimit, magicLinkLockout)
+
+	// an account that had enough links is answered like any other request so
+	// the limit does not give away which accounts exist
+	dbUser := s.db.GetUser(linkData.Identifier, "password")
+	accountKey := ""
+	if dbUser != nil {
+		accountKey = magicLinkThrottleKey(accountThrottleKey(dbUser))
+	}
+	if dbUser == nil {
+		s.recordFailure(ipThrottleKey(r), ipLockoutThreshold, ipLockoutDuration)
+	} else if dbUser.IsVerified && s.retryAfter(accountKey) == 0 {
+		s.recordFailure(accountKey, magicLinkAccountLimit, magicLinkLockout)
+
+		jti, err := utils.RandomToken(16)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+
+		token, err := utils.CreateMagicLinkToken(dbUser.ID.Hex(), jti)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error creating sign-in link", Error: err.Error()}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+
+		err = s.db.SetMagicLinkID(dbUser.ID, jti)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+
+		link := utils.AppURL() + "/magic-link?token=" + url.QueryEscape(token)
+		go func() {
+			if err := email.SendMagicLinkEmail(dbUser.Username, dbUser.Email, link); err != nil {
+				log.Printf("error sending magic link email: %v", err)
+			}
+		}()
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "if the account exists and is verified, a sign-in link is on its way"}
+	json.NewEncoder(w).Encode(res)
+}
+
+// MagicLinkCallback exchanges the emailed token for the regular session cookie.
+func (s *Server) MagicLinkCallback(w http.ResponseWriter, r *http.Request) {
+	var callbackData types.MagicLinkCallbackType
+	err := json.NewDecoder(r.Body).Decode(&callbackData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid input", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer r.Body.Close()
+
+	var validate = validator.New()
+	err = validate.Struct(callbackData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "validation failed", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if wait := s.retryAfter(ipThrottleKey(r)); wait > 0 {
+		writeTooManyAttempts(w, wait)
+		return
+	}
+
+	claims, err := utils.VerifyPurposeJWT(callbackData.Token, utils.PurposeMagicLink)
+	if err != nil {
+		s.recordFailure(ipThrottleKey(r), ipLockoutThreshold, ipLockoutDuration)
+		w.WriteHeader(http.StatusUnauthorized)
+		res := types.Response{StatusCode: http.StatusUnauthorized, Success: false, Message: "sign-in link is invalid or expired", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	userIdObjectId, err := primitive.ObjectIDFromHex(claims["user_id"].(string))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	jti, _ := claims["jti"].(string)
+	if jti == "" || !s.db.ConsumeMagicLink(userIdObjectId, jti) {
+		w.WriteHeader(http.StatusUnauthorized)
+		res := types.Response{StatusCode: http.StatusUnauthorized, Success: false, Message: "sign-in link was already used"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	dbUser := s.db.GetUserByID(userIdObjectId)
+	if dbUser == nil {
+		w.WriteHeader(http.StatusNotFound)
+		res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "user not found"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if !dbUser.IsVerified {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "please verify your account before singing in, check email"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if wait := s.retryAfter(accountThrottleKey(dbUser)); wait > 0 {
+		writeTooManyAttempts(w, wait)
+		return
+	}
+
+	s.beginSession(w, r, dbUser)
+}
@@ -0,0 +1,251 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"silent-notes/internal/database"
+	"silent-notes/internal/models"
+	"silent-notes/internal/types"
+	"silent-notes/internal/utils"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// most message ids a bulk call lists in its results, a filter may change
+// more and the response says the list was truncated
+const maxBulkMessages = 1000
+
+// BulkMessages deletes, archives or marks read a list of messages, or every
+// message matching a filter, in one write.
+func (s *Server) BulkMessages(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	var bulkData types.BulkMessageType
+	err = json.NewDecoder(r.Body).Decode(&bulkData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid input", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer r.Body.Close()
+
+	var validate = validator.New()
+	err = validate.Struct(bulkData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "validation failed", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if (len(bulkData.IDs) > 0) == (bulkData.Filter != nil) {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "send either ids or a filter"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	results := map[string]string{}
+	var filter models.MessageFilter
+	if bulkData.Filter != nil {
+		if bulkData.Filter.OlderThan.IsZero() && bulkData.Filter.Contains == "" && !bulkData.Filter.Unread {
+			w.WriteHeader(http.StatusBadRequest)
+			res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "filter needs older_than, contains or unread"}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		filter.Before = bulkData.Filter.OlderThan
+		filter.Contains = bulkData.Filter.Contains
+		if bulkData.Filter.Unread {
+			filter.State = models.MessageUnread
+		}
+	} else {
+		filter.IDs = []primitive.ObjectID{}
+		for _, id := range bulkData.IDs {
+			messageId, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				results[id] = "invalid_id"
+				continue
+			}
+			if _, seen := results[messageId.Hex()]; !seen {
+				filter.IDs = append(filter.IDs, messageId)
+				results[messageId.Hex()] = "not_found"
+			}
+		}
+	}
+
+	var readExpiry *time.Time
+	if bulkData.Action == models.MessageActionMarkRead {
+		if user := s.db.GetUserByID(userIdObjectId); user != nil && user.Retention.AfterRead {
+			at := time.Now().Add(utils.ReadMessageTTL())
+			readExpiry = &at
+		}
+	}
+
+	ids, changed, err := s.db.ApplyMessageAction(userIdObjectId, filter, bulkData.Action, readExpiry, maxBulkMessages)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error updating messages", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	for _, id := range ids {
+		results[id.Hex()] = "ok"
+	}
+	// these now expire, their cached cards must not outlive them
+	if readExpiry != nil {
+		s.removeCards(userIdObjectId, ids)
+	}
+
+	// listed messages the action left alone exist but were already deleted,
+	// archived or read
+	if bulkData.Filter == nil && int64(len(ids)) < int64(len(filter.IDs)) {
+		unchanged := []primitive.ObjectID{}
+		for _, id := range filter.IDs {
+			if results[id.Hex()] != "ok" {
+				unchanged = append(unchanged, id)
+			}
+		}
+		existing, err := s.db.MessageIDs(userIdObjectId, models.MessageFilter{IDs: unchanged}, 0)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		for _, id := range existing {
+			results[id.Hex()] = "unchanged"
+		}
+	}
+
+	if bulkData.Action == models.MessageActionDelete && changed > 0 {
+		s.audit(r, userIdObjectId, models.AuditMessageDeleted, map[string]string{"count": strconv.FormatInt(changed, 10)})
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "messages updated", Data: map[string]interface{}{
+		"action":    bulkData.Action,
+		"changed":   changed,
+		"results":   results,
+		"truncated": changed > int64(len(ids)),
+	}}
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) GetTrash(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	messages, err := s.db.GetTrashedMessages(userIdObjectId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "messages in trash", Data: map[string]interface{}{
+		"messages":       messages,
+		"retention_days": int(utils.TrashRetention().Hours() / 24),
+	}}
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) RestoreMessage(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	messageId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "mId"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid message id", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	err = s.db.RestoreMessage(userIdObjectId, messageId)
+	if err != nil {
+		if errors.Is(err, database.ErrMessageNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "message not found in trash", Error: err.Error()}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "message restored"}
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) EmptyTrash(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	deleted, err := s.db.EmptyTrash(userIdObjectId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	s.removeCards(userIdObjectId, deleted)
+	s.audit(r, userIdObjectId, models.AuditTrashEmptied, map[string]string{"count": strconv.Itoa(len(deleted))})
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "trash emptied", Data: map[string]interface{}{"deleted": len(deleted)}}
+	json.NewEncoder(w).Encode(res)
+}
+
+// purgeTrashedMessages deletes messages that have been in the trash longer
+// than the retention period.
+func (s *Server) purgeTrashedMessages() error {
+	purged, err := s.db.PurgeTrashedMessages(time.Now().Add(-utils.TrashRetention()))
+	if err != nil {
+		return err
+	}
+	for _, message := range purged {
+		s.removeCards(message.UserID, []primitive.ObjectID{message.ID})
+	}
+	if len(purged) > 0 {
+		log.Printf("purged %d trashed messages", len(purged))
+	}
+	return nil
+}
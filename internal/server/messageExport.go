@@ -0,0 +1,262 @@
+package server
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"silent-notes/internal/models"
+	"silent-notes/internal/types"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// flushed to the client every this many messages while exporting
+const exportFlushEvery = 200
+
+type messageWriter interface {
+	WriteMessage(message models.Message) error
+	Flush() error
+}
+
+var exportFormats = map[string]struct {
+	contentType string
+	extension   string
+}{
+	"csv":    {"text/csv; charset=utf-8", "csv"},
+	"ndjson": {"application/x-ndjson", "ndjson"},
+	"md":     {"text/markdown; charset=utf-8", "md"},
+}
+
+// ExportMessages streams the user's inbox as CSV, NDJSON or Markdown straight
+// from the database cursor, so the size of the inbox does not matter.
+func (s *Server) ExportMessages(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	formatName := strings.ToLower(r.URL.Query().Get("format"))
+	if formatName == "" {
+		formatName = "csv"
+	}
+	format, ok := exportFormats[formatName]
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "format must be csv, ndjson or md"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	filter, err := parseMessageFilter(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid filter", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	cursor, err := s.db.StreamMessages(r.Context(), userIdObjectId, filter)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer cursor.Close(r.Context())
+
+	// the server write timeout is sized for JSON responses, not whole inboxes
+	controller := http.NewResponseController(w)
+	controller.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", format.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="silent-notes-messages-%s.%s"`, time.Now().Format("2006-01-02"), format.extension))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	out := bufio.NewWriter(w)
+	var writer messageWriter
+	switch formatName {
+	case "csv":
+		writer = newCSVMessageWriter(out)
+	case "ndjson":
+		writer = &ndjsonMessageWriter{encoder: json.NewEncoder(out), out: out}
+	case "md":
+		writer = newMarkdownMessageWriter(out, filter)
+	}
+
+	count := 0
+	for cursor.Next(r.Context()) {
+		var message models.Message
+		if err := cursor.Decode(&message); err != nil {
+			log.Printf("error decoding message during export: %v", err)
+			return
+		}
+		if err := writer.WriteMessage(message); err != nil {
+			return
+		}
+		count++
+		if count%exportFlushEvery == 0 {
+			if writer.Flush() != nil || controller.Flush() != nil {
+				return
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		// headers are gone, all we can do is cut the download short
+		log.Printf("error streaming messages for export: %v", err)
+		return
+	}
+	if writer.Flush() == nil {
+		controller.Flush()
+	}
+}
+
+// parseMessageFilter reads the from, to and state query parameters. Dates are
+// RFC 3339 timestamps or plain YYYY-MM-DD days, a plain day in to includes
+// that whole day.
+func parseMessageFilter(r *http.Request) (models.MessageFilter, error) {
+	var filter models.MessageFilter
+	query := r.URL.Query()
+
+	if from := query.Get("from"); from != "" {
+		t, _, err := parseFilterTime(from)
+		if err != nil {
+			return filter, fmt.Errorf("from: %w", err)
+		}
+		filter.From = t
+	}
+	if to := query.Get("to"); to != "" {
+		t, dayOnly, err := parseFilterTime(to)
+		if err != nil {
+			return filter, fmt.Errorf("to: %w", err)
+		}
+		if dayOnly {
+			t = t.Add(24*time.Hour - time.Nanosecond)
+		}
+		filter.To = t
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && filter.To.Before(filter.From) {
+		return filter, fmt.Errorf("to is before from")
+	}
+
+	if state := query.Get("state"); state != "" {
+		if !models.IsMessageState(state) {
+			return filter, fmt.Errorf("state must be unread, read or archived")
+		}
+		filter.State = state
+	}
+	return filter, nil
+}
+
+func parseFilterTime(value string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, false, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("expected RFC 3339 or YYYY-MM-DD, got %q", value)
+	}
+	return t, true, nil
+}
+
+type csvMessageWriter struct {
+	writer *csv.Writer
+	out    *bufio.Writer
+}
+
+func newCSVMessageWriter(out *bufio.Writer) *csvMessageWriter {
+	writer := csv.NewWriter(out)
+	writer.Write([]string{"id", "created_at", "state", "content"})
+	return &csvMessageWriter{writer: writer, out: out}
+}
+
+func (c *csvMessageWriter) WriteMessage(message models.Message) error {
+	return c.writer.Write([]string{
+		message.ID.Hex(),
+		message.CreatedAt.UTC().Format(time.RFC3339),
+		message.State,
+		csvSafe(message.Content),
+	})
+}
+
+func (c *csvMessageWriter) Flush() error {
+	c.writer.Flush()
+	if err := c.writer.Error(); err != nil {
+		return err
+	}
+	return c.out.Flush()
+}
+
+// csvSafe stops spreadsheets from running an anonymous sender's text as a formula.
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+type ndjsonMessageWriter struct {
+	encoder *json.Encoder
+	out     *bufio.Writer
+}
+
+func (n *ndjsonMessageWriter) WriteMessage(message models.Message) error {
+	return n.encoder.Encode(message)
+}
+
+func (n *ndjsonMessageWriter) Flush() error {
+	return n.out.Flush()
+}
+
+type markdownMessageWriter struct {
+	out *bufio.Writer
+}
+
+func newMarkdownMessageWriter(out *bufio.Writer, filter models.MessageFilter) *markdownMessageWriter {
+	fmt.Fprintln(out, "# Silent Notes messages")
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "Exported %s", time.Now().UTC().Format(time.RFC1123))
+	if !filter.From.IsZero() {
+		fmt.Fprintf(out, ", from %s", filter.From.UTC().Format(time.RFC1123))
+	}
+	if !filter.To.IsZero() {
+		fmt.Fprintf(out, ", to %s", filter.To.UTC().Format(time.RFC1123))
+	}
+	if filter.State != "" {
+		fmt.Fprintf(out, ", %s only", filter.State)
+	}
+	fmt.Fprintln(out, ".")
+	return &markdownMessageWriter{out: out}
+}
+
+func (m *markdownMessageWriter) WriteMessage(message models.Message) error {
+	fmt.Fprintf(m.out, "\n## %s", message.CreatedAt.UTC().Format(time.RFC1123))
+	if message.State != "" && message.State != models.MessageRead {
+		fmt.Fprintf(m.out, " (%s)", message.State)
+	}
+	fmt.Fprintln(m.out)
+	fmt.Fprintln(m.out)
+	for _, line := range strings.Split(strings.ReplaceAll(message.Content, "\r\n", "\n"), "\n") {
+		if line == "" {
+			fmt.Fprintln(m.out, ">")
+			continue
+		}
+		if _, err := fmt.Fprintf(m.out, "> %s\n", line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *markdownMessageWriter) Flush() error {
+	return m.out.Flush()
+}
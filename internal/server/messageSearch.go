@@ -0,0 +1,184 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"silent-notes/internal/types"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	// runes of context kept on each side of the first match in a snippet
+	snippetContext = 60
+)
+
+// a quoted phrase or a single word, terms starting with - are exclusions
+var searchTermPattern = regexp.MustCompile(`-?"[^"]+"|\S+`)
+
+type searchHit struct {
+	ID         primitive.ObjectID `json:"id"`
+	Content    string             `json:"content"`
+	State      string             `json:"state,omitempty"`
+	CreatedAt  time.Time          `json:"created_at"`
+	Score      float64            `json:"score"`
+	Snippet    string             `json:"snippet"`
+	Highlights [][2]int           `json:"highlights"`
+}
+
+// SearchMessages finds messages by text, most relevant first. It accepts the
+// same from, to and state filters as the export.
+func (s *Server) SearchMessages(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	search := strings.TrimSpace(r.URL.Query().Get("q"))
+	if search == "" || len(search) > 300 {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "q must be between 1 and 300 characters"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	filter, err := parseMessageFilter(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid filter", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	limit, page, err := parsePage(r, defaultSearchLimit, maxSearchLimit)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid paging", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	results, err := s.db.SearchMessages(userIdObjectId, search, filter, int64(limit), int64((page-1)*limit))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	terms := searchTerms(search)
+	hits := make([]searchHit, 0, len(results))
+	for _, result := range results {
+		snippet, highlights := highlightSnippet(result.Content, terms)
+		hits = append(hits, searchHit{
+			ID:         result.ID,
+			Content:    result.Content,
+			State:      result.State,
+			CreatedAt:  result.CreatedAt,
+			Score:      result.Score,
+			Snippet:    snippet,
+			Highlights: highlights,
+		})
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "search results", Data: map[string]interface{}{
+		"results": hits,
+		"page":    page,
+		"limit":   limit,
+	}}
+	json.NewEncoder(w).Encode(res)
+}
+
+// parsePage reads the limit and page query parameters, page counts from 1.
+func parsePage(r *http.Request, defaultLimit, maxLimit int) (int, int, error) {
+	limit, page := defaultLimit, 1
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxLimit {
+			return 0, 0, strconv.ErrRange
+		}
+		limit = n
+	}
+	if value := r.URL.Query().Get("page"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return 0, 0, strconv.ErrRange
+		}
+		page = n
+	}
+	return limit, page, nil
+}
+
+// searchTerms lists the words and phrases of a search that should be
+// highlighted, exclusions are dropped.
+func searchTerms(search string) []string {
+	var terms []string
+	for _, term := range searchTermPattern.FindAllString(search, -1) {
+		if strings.HasPrefix(term, "-") {
+			continue
+		}
+		term = strings.Trim(term, `"`)
+		if term != "" {
+			terms = append(terms, strings.ToLower(term))
+		}
+	}
+	return terms
+}
+
+// highlightSnippet cuts the content down to the area around the first match
+// and returns the rune offsets of every match inside the snippet.
+func highlightSnippet(content string, terms []string) (string, [][2]int) {
+	runes := []rune(content)
+	lower := []rune(strings.ToLower(content))
+	// lowercasing can change the length of some runes, fall back to exact matching
+	if len(lower) != len(runes) {
+		lower = runes
+	}
+
+	var matches [][2]int
+	for _, term := range terms {
+		needle := []rune(term)
+		for i := 0; i+len(needle) <= len(lower); i++ {
+			if string(lower[i:i+len(needle)]) == term {
+				matches = append(matches, [2]int{i, i + len(needle)})
+				i += len(needle) - 1
+			}
+		}
+	}
+
+	start, end := 0, len(runes)
+	if len(matches) > 0 {
+		first := matches[0]
+		for _, match := range matches {
+			if match[0] < first[0] {
+				first = match
+			}
+		}
+		start = max(0, first[0]-snippetContext)
+		end = min(len(runes), first[1]+snippetContext)
+	} else if end > 2*snippetContext {
+		end = 2 * snippetContext
+	}
+
+	highlights := [][2]int{}
+	for _, match := range matches {
+		if match[0] >= start && match[1] <= end {
+			highlights = append(highlights, [2]int{match[0] - start, match[1] - start})
+		}
+	}
+
+	sort.Slice(highlights, func(i, j int) bool { return highlights[i][0] < highlights[j][0] })
+	return string(runes[start:end]), highlights
+}
@@ -0,0 +1,358 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"silent-notes/internal/models"
+	"silent-notes/internal/types"
+	"silent-notes/internal/utils"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/skip2/go-qrcode"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (s *Server) EnrollMFA(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer r.Body.Close()
+
+	user := s.db.GetUserByID(userIdObjectId)
+	if user == nil {
+		w.WriteHeader(http.StatusNotFound)
+		res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "user not found"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if user.MFAEnabled {
+		w.WriteHeader(http.StatusConflict)
+		res := types.Response{StatusCode: http.StatusConflict, Success: false, Message: "two-factor authentication already enabled"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	uri := utils.TOTPURI(user.Username, secret)
+	qr, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error generating qr code", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	err = s.db.SetPendingMFASecret(userIdObjectId, secret)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "scan the qr code and confirm with a code from your app", Data: map[string]interface{}{
+		"secret":      secret,
+		"otpauth_uri": uri,
+		"qr_png":      "data:image/png;base64," + base64.StdEncoding.EncodeToString(qr),
+	}}
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) ConfirmMFA(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	var confirmData types.MFACodeType
+	err = json.NewDecoder(r.Body).Decode(&confirmData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid input", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer r.Body.Close()
+
+	var validate = validator.New()
+	err = validate.Struct(confirmData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "validation failed", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	user := s.db.GetUserByID(userIdObjectId)
+	if user == nil || user.MFAPendingSecret == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "no pending two-factor enrollment"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	step, ok := utils.ValidateTOTP(user.MFAPendingSecret, confirmData.Code, 0)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid code"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	codes, hashes, err := utils.GenerateRecoveryCodes()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	err = s.db.EnableMFA(userIdObjectId, user.MFAPendingSecret, step, hashes)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error enabling two-factor authentication", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	s.audit(r, userIdObjectId, models.AuditMFAEnabled, nil)
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "two-factor authentication enabled, store your recovery codes safely", Data: map[string]interface{}{"recovery_codes": codes}}
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) DisableMFA(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	var disableData types.MFADisableType
+	err = json.NewDecoder(r.Body).Decode(&disableData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid input", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer r.Body.Close()
+
+	var validate = validator.New()
+	err = validate.Struct(disableData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "validation failed", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	user := s.db.GetUserByID(userIdObjectId)
+	if user == nil || !user.MFAEnabled {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "two-factor authentication is not enabled"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if wait := s.retryAfter(accountThrottleKey(user)); wait > 0 {
+		writeTooManyAttempts(w, wait)
+		return
+	}
+
+	if !utils.CheckPassword(disableData.Password, user.Password) || !s.checkSecondFactor(user, disableData.Code, disableData.RecoveryCode) {
+		s.recordSignInFailure(r, user)
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "wrong credentials", Error: "wrong credentials"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	err = s.db.DisableMFA(userIdObjectId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error disabling two-factor authentication", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	s.audit(r, userIdObjectId, models.AuditMFADisabled, nil)
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "two-factor authentication disabled"}
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	var codeData types.MFACodeType
+	err = json.NewDecoder(r.Body).Decode(&codeData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid input", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer r.Body.Close()
+
+	var validate = validator.New()
+	err = validate.Struct(codeData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "validation failed", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	user := s.db.GetUserByID(userIdObjectId)
+	if user == nil || !user.MFAEnabled {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "two-factor authentication is not enabled"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if wait := s.retryAfter(accountThrottleKey(user)); wait > 0 {
+		writeTooManyAttempts(w, wait)
+		return
+	}
+
+	if !s.checkSecondFactor(user, codeData.Code, "") {
+		s.recordSignInFailure(r, user)
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid code"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	codes, hashes, err := utils.GenerateRecoveryCodes()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	err = s.db.SetRecoveryCodes(userIdObjectId, hashes)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	s.audit(r, userIdObjectId, models.AuditRecoveryCodesReplaced, nil)
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "recovery codes regenerated, the old ones no longer work", Data: map[string]interface{}{"recovery_codes": codes}}
+	json.NewEncoder(w).Encode(res)
+}
+
+// SignInMFA exchanges the challenge token from SignIn plus a second factor for a session.
+func (s *Server) SignInMFA(w http.ResponseWriter, r *http.Request) {
+	var mfaData types.MFASignInType
+	err := json.NewDecoder(r.Body).Decode(&mfaData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid input", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer r.Body.Close()
+
+	var validate = validator.New()
+	err = validate.Struct(mfaData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "validation failed", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	mfaToken := mfaData.MFAToken
+	if mfaToken == "" {
+		if cookie, err := r.Cookie(mfaTokenCookie); err == nil {
+			mfaToken = cookie.Value
+		}
+	}
+
+	claims, err := utils.VerifyPurposeJWT(mfaToken, utils.PurposeMFA)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		res := types.Response{StatusCode: http.StatusUnauthorized, Success: false, Message: "mfa challenge expired, sign in again", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	userIdObjectId, err := primitive.ObjectIDFromHex(claims["user_id"].(string))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	dbUser := s.db.GetUserByID(userIdObjectId)
+	if dbUser == nil || !dbUser.MFAEnabled {
+		w.WriteHeader(http.StatusUnauthorized)
+		res := types.Response{StatusCode: http.StatusUnauthorized, Success: false, Message: "mfa challenge expired, sign in again"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if wait := max(s.retryAfter(ipThrottleKey(r)), s.retryAfter(accountThrottleKey(dbUser))); wait > 0 {
+		writeTooManyAttempts(w, wait)
+		return
+	}
+
+	if !s.checkSecondFactor(dbUser, mfaData.Code, mfaData.RecoveryCode) {
+		s.recordSignInFailure(r, dbUser)
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid code"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: mfaTokenCookie, Value: "", Path: "/api/v1/sign-in/mfa", MaxAge: -1, HttpOnly: true})
+	s.completeSignIn(w, r, dbUser)
+}
+
+// checkSecondFactor accepts either a fresh TOTP code or an unused recovery code.
+func (s *Server) checkSecondFactor(user *models.UserModel, code, recoveryCode string) bool {
+	if code != "" {
+		step, ok := utils.ValidateTOTP(user.MFASecret, code, user.MFALastStep)
+		return ok && s.db.ConsumeTOTPStep(user.ID, step)
+	}
+	if recoveryCode != "" {
+		return s.db.ConsumeRecoveryCode(user.ID, utils.HashRecoveryCode(recoveryCode))
+	}
+	return false
+}
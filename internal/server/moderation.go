@@ -0,0 +1,520 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"silent-notes/internal/database"
+	"silent-notes/internal/models"
+	"silent-notes/internal/types"
+	"silent-notes/internal/utils"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultModerationLimit = 50
+	maxModerationLimit     = 200
+)
+
+// ReportMessage flags one of the user's messages for the moderators.
+func (s *Server) ReportMessage(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	messageId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "mId"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid message id", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	var reportData types.ReportType
+	err = json.NewDecoder(r.Body).Decode(&reportData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid input", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer r.Body.Close()
+
+	var validate = validator.New()
+	err = validate.Struct(reportData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "validation failed", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	message, err := s.db.GetMessage(userIdObjectId, messageId)
+	if err != nil {
+		if errors.Is(err, database.ErrMessageNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "message not found", Error: err.Error()}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	report := models.Report{
+		ID:          primitive.NewObjectID(),
+		MessageID:   message.ID,
+		ReporterID:  userIdObjectId,
+		Category:    reportData.Category,
+		Details:     strings.TrimSpace(reportData.Details),
+		Content:     message.Content,
+		Fingerprint: message.Fingerprint,
+		Status:      models.ReportOpen,
+		CreatedAt:   time.Now(),
+	}
+	if message.FollowUp != nil {
+		report.FollowUp = message.FollowUp.Content
+	}
+	err = s.db.CreateReport(report)
+	if err != nil {
+		if errors.Is(err, database.ErrReportExists) {
+			w.WriteHeader(http.StatusConflict)
+			res := types.Response{StatusCode: http.StatusConflict, Success: false, Message: "message has already been reported"}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	res := types.Response{StatusCode: http.StatusCreated, Success: true, Message: "message reported", Data: map[string]interface{}{"report_id": report.ID}}
+	json.NewEncoder(w).Encode(res)
+}
+
+// GetReports is the moderation queue, ?status= defaults to open and
+// ?category= narrows it down.
+func (s *Server) GetReports(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = models.ReportOpen
+	}
+	if status != models.ReportOpen && status != models.ReportDismissed && status != models.ReportRemoved && status != "all" {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "status must be open, dismissed, removed or all"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	if status == "all" {
+		status = ""
+	}
+
+	category := r.URL.Query().Get("category")
+	if category != "" && !models.IsReportCategory(category) {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "unknown report category"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	limit, page, err := parsePage(r, defaultModerationLimit, maxModerationLimit)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid paging", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	reports, err := s.db.GetReports(status, category, int64(limit), int64((page-1)*limit))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "reports", Data: map[string]interface{}{
+		"reports": reports,
+		"page":    page,
+		"limit":   limit,
+	}}
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) GetReport(w http.ResponseWriter, r *http.Request) {
+	report, ok := s.loadReport(w, r)
+	if !ok {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "report", Data: map[string]interface{}{"report": report}}
+	json.NewEncoder(w).Encode(res)
+}
+
+// DismissReport closes a report without acting on the message.
+func (s *Server) DismissReport(w http.ResponseWriter, r *http.Request) {
+	s.resolveReport(w, r, models.ReportDismissed)
+}
+
+// RemoveReportedMessage deletes the reported message for good and closes the report.
+func (s *Server) RemoveReportedMessage(w http.ResponseWriter, r *http.Request) {
+	s.resolveReport(w, r, models.ReportRemoved)
+}
+
+func (s *Server) resolveReport(w http.ResponseWriter, r *http.Request, status string) {
+	moderatorId, err := primitive.ObjectIDFromHex(r.Context().Value(types.UserIDKey).(string))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	// the note is optional, so is the body
+	var noteData types.ModerationNoteType
+	err = json.NewDecoder(r.Body).Decode(&noteData)
+	if err != nil && !errors.Is(err, io.EOF) {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid input", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer r.Body.Close()
+
+	var validate = validator.New()
+	err = validate.Struct(noteData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "validation failed", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	report, ok := s.loadReport(w, r)
+	if !ok {
+		return
+	}
+	if report.Status != models.ReportOpen {
+		w.WriteHeader(http.StatusConflict)
+		res := types.Response{StatusCode: http.StatusConflict, Success: false, Message: "report has already been resolved"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if status == models.ReportRemoved {
+		if err := s.db.RemoveMessage(report.MessageID); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error removing message", Error: err.Error()}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+	}
+
+	err = s.db.ResolveReport(report.ID, status, moderatorId, strings.TrimSpace(noteData.Note))
+	if err != nil {
+		if errors.Is(err, database.ErrReportNotFound) {
+			w.WriteHeader(http.StatusConflict)
+			res := types.Response{StatusCode: http.StatusConflict, Success: false, Message: "report has already been resolved"}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	action := "report.dismiss"
+	if status == models.ReportRemoved {
+		action = "report.remove_message"
+	}
+	s.logModeration(r, moderatorId, action, report.ID, report.MessageID, noteData.Note)
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "report " + status}
+	json.NewEncoder(w).Encode(res)
+}
+
+// CreateSuspension suspends the sender of a reported message by their
+// fingerprint, which only lasts as long as the fingerprint can match, or an
+// account, which can then neither sign in nor receive messages.
+func (s *Server) CreateSuspension(w http.ResponseWriter, r *http.Request) {
+	moderatorId, err := primitive.ObjectIDFromHex(r.Context().Value(types.UserIDKey).(string))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	var suspensionData types.SuspensionType
+	err = json.NewDecoder(r.Body).Decode(&suspensionData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid input", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer r.Body.Close()
+
+	var validate = validator.New()
+	err = validate.Struct(suspensionData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "validation failed", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	s.createSuspension(w, r, moderatorId, suspensionData)
+}
+
+// createSuspension stores a validated suspension and writes the response.
+func (s *Server) createSuspension(w http.ResponseWriter, r *http.Request, moderatorId primitive.ObjectID, suspensionData types.SuspensionType) {
+	now := time.Now()
+	suspension := models.Suspension{
+		ID:        primitive.NewObjectID(),
+		Kind:      suspensionData.Kind,
+		Reason:    strings.TrimSpace(suspensionData.Reason),
+		CreatedBy: moderatorId,
+		CreatedAt: now,
+	}
+	if suspensionData.Days > 0 {
+		expiresAt := now.AddDate(0, 0, suspensionData.Days)
+		suspension.ExpiresAt = &expiresAt
+	}
+
+	var target primitive.ObjectID
+	if suspension.Kind == models.SuspendSender {
+		reportId, _ := primitive.ObjectIDFromHex(suspensionData.ReportID)
+		report, err := s.db.GetReport(reportId)
+		if err != nil {
+			status, message := http.StatusInternalServerError, "internal server error"
+			if errors.Is(err, database.ErrReportNotFound) {
+				status, message = http.StatusNotFound, "report not found"
+			}
+			w.WriteHeader(status)
+			res := types.Response{StatusCode: status, Success: false, Message: message, Error: err.Error()}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+
+		epoch, ok := utils.ParseFingerprintEpoch(report.Fingerprint)
+		if !ok || !utils.FingerprintExpiry(epoch).After(now) {
+			w.WriteHeader(http.StatusGone)
+			res := types.Response{StatusCode: http.StatusGone, Success: false, Message: "the reported message is too old to suspend its sender"}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		// past this point the fingerprint cannot match anyone
+		if expiry := utils.FingerprintExpiry(epoch); suspension.ExpiresAt == nil || suspension.ExpiresAt.After(expiry) {
+			suspension.ExpiresAt = &expiry
+		}
+		suspension.Fingerprint = report.Fingerprint
+		suspension.ReportID = report.ID
+		target = report.ID
+	} else {
+		userId, _ := primitive.ObjectIDFromHex(suspensionData.UserID)
+		user := s.db.GetUserByID(userId)
+		if user == nil {
+			w.WriteHeader(http.StatusNotFound)
+			res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "user not found"}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		if user.EffectiveRole() != models.RoleUser {
+			w.WriteHeader(http.StatusForbidden)
+			res := types.Response{StatusCode: http.StatusForbidden, Success: false, Message: "staff accounts have to be demoted before they can be suspended"}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		if userId == moderatorId {
+			w.WriteHeader(http.StatusBadRequest)
+			res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "you cannot suspend yourself"}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		suspension.UserID = userId
+		target = userId
+	}
+
+	if err := s.db.AddSuspension(suspension); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	s.logModeration(r, moderatorId, "suspend."+suspension.Kind, suspension.ReportID, target, suspension.Reason)
+	if suspension.Kind == models.SuspendUser {
+		if _, err := s.db.DeleteSessions(suspension.UserID, primitive.NilObjectID); err != nil {
+			log.Printf("error signing out suspended user %s: %v", suspension.UserID.Hex(), err)
+		}
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	res := types.Response{StatusCode: http.StatusCreated, Success: true, Message: suspension.Kind + " suspended", Data: map[string]interface{}{"suspension": suspension}}
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) GetSuspensions(w http.ResponseWriter, r *http.Request) {
+	suspensions, err := s.db.GetSuspensions()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "suspensions", Data: map[string]interface{}{"suspensions": suspensions}}
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) LiftSuspension(w http.ResponseWriter, r *http.Request) {
+	moderatorId, err := primitive.ObjectIDFromHex(r.Context().Value(types.UserIDKey).(string))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	suspensionId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "suspensionId"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid suspension id", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	suspension, err := s.db.DeleteSuspension(suspensionId)
+	if err != nil {
+		if errors.Is(err, database.ErrSuspensionNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "suspension not found", Error: err.Error()}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	target := suspension.UserID
+	if suspension.Kind == models.SuspendSender {
+		target = suspension.ReportID
+	}
+	s.logModeration(r, moderatorId, "unsuspend."+suspension.Kind, suspension.ReportID, target, "")
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "suspension lifted"}
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) GetModerationLog(w http.ResponseWriter, r *http.Request) {
+	limit, page, err := parsePage(r, defaultModerationLimit, maxModerationLimit)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid paging", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	actions, err := s.db.GetModerationLog(int64(limit), int64((page-1)*limit))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "moderation log", Data: map[string]interface{}{
+		"actions": actions,
+		"page":    page,
+		"limit":   limit,
+	}}
+	json.NewEncoder(w).Encode(res)
+}
+
+// loadReport reads the {reportId} route parameter and writes the error
+// response itself when the report cannot be loaded.
+func (s *Server) loadReport(w http.ResponseWriter, r *http.Request) (*models.Report, bool) {
+	reportId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "reportId"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid report id", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return nil, false
+	}
+
+	report, err := s.db.GetReport(reportId)
+	if err != nil {
+		if errors.Is(err, database.ErrReportNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "report not found", Error: err.Error()}
+			json.NewEncoder(w).Encode(res)
+			return nil, false
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return nil, false
+	}
+	return report, true
+}
+
+// logModeration records a moderator's action. The action has already
+// happened by then, so a failed write is logged rather than undone. It also
+// goes into the audit trail as "admin." plus the action.
+func (s *Server) logModeration(r *http.Request, moderatorId primitive.ObjectID, action string, reportId, targetId primitive.ObjectID, note string) {
+	note = strings.TrimSpace(note)
+	err := s.db.LogModeration(models.ModerationAction{
+		ID:          primitive.NewObjectID(),
+		ModeratorID: moderatorId,
+		Action:      action,
+		ReportID:    reportId,
+		TargetID:    targetId,
+		Note:        note,
+		CreatedAt:   time.Now(),
+	})
+	if err != nil {
+		log.Printf("error logging moderation action %s by %s: %v", action, moderatorId.Hex(), err)
+	}
+
+	event := models.AuditEvent{UserID: moderatorId, Action: models.AuditAdminPrefix + action, Details: map[string]string{}}
+	if !reportId.IsZero() {
+		event.Details["report_id"] = reportId.Hex()
+	}
+	if !targetId.IsZero() {
+		event.Details["target_id"] = targetId.Hex()
+	}
+	if note != "" {
+		event.Details["note"] = note
+	}
+	// report and sender actions target messages, the rest target an account
+	// and show up under it for admins too
+	if strings.HasPrefix(action, "role.") || strings.HasPrefix(action, "user.") || strings.HasSuffix(action, "."+models.SuspendUser) {
+		event.TargetID = targetId
+	}
+	s.addAuditEvent(r, event)
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"silent-notes/internal/models"
+	"silent-notes/internal/types"
+	"silent-notes/internal/utils"
+	"silent-notes/internal/utils/oidc"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	// mfaTokenCookie carries the second factor challenge from an OIDC login
+	// to SignInMFA, kept out of the redirect URL so it never ends up in
+	// browser history or Referer headers
+	mfaTokenCookie = "mfa_token"
+)
+
+// OAuthLogin starts the authorization code flow with PKCE and redirects the
+// browser to the provider.
+func (s *Server) OAuthLogin(w http.ResponseWriter, r *http.Request) {
+	provider := oidc.GetProvider(chi.URLParam(r, "provider"))
+	if provider == nil {
+		w.WriteHeader(http.StatusNotFound)
+		res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "unknown login provider"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	var values [3]string
+	for i := range values {
+		value, err := utils.RandomToken(32)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		values[i] = value
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	authURL, err := provider.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		res := types.Response{StatusCode: http.StatusBadGateway, Success: false, Message: "login provider unavailable", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	stateToken, err := utils.CreateOIDCStateToken(provider.Name, state, nonce, verifier)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    stateToken,
+		Path:     "/api/v1/oauth",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OAuthCallback finishes the flow: it verifies the ID token, finds or
+// provisions the local user and signs them in, then sends the browser back to
+// the frontend.
+func (s *Server) OAuthCallback(w http.ResponseWriter, r *http.Request) {
+	provider := oidc.GetProvider(chi.URLParam(r, "provider"))
+	if provider == nil {
+		redirectSignInError(w, r, "unknown_provider")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Value: "", Path: "/api/v1/oauth", MaxAge: -1, HttpOnly: true})
+
+	if r.URL.Query().Get("error") != "" {
+		redirectSignInError(w, r, "access_denied")
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		redirectSignInError(w, r, "invalid_state")
+		return
+	}
+	claims, err := utils.VerifyPurposeJWT(cookie.Value, utils.PurposeOIDCState)
+	if err != nil || claims["provider"] != provider.Name || claims["state"] != r.URL.Query().Get("state") {
+		redirectSignInError(w, r, "invalid_state")
+		return
+	}
+
+	nonce, _ := claims["nonce"].(string)
+	verifier, _ := claims["verifier"].(string)
+	identity, err := provider.Exchange(r.Context(), r.URL.Query().Get("code"), verifier, nonce)
+	if err != nil {
+		log.Printf("oidc exchange with %s failed: %v", provider.Name, err)
+		redirectSignInError(w, r, "exchange_failed")
+		return
+	}
+
+	dbUser := s.db.GetUserByIdentity(provider.Name, identity.Subject)
+	if dbUser == nil {
+		if identity.Email == "" || !identity.EmailVerified {
+			redirectSignInError(w, r, "email_not_verified")
+			return
+		}
+
+		link := models.ExternalIdentity{Provider: provider.Name, Subject: identity.Subject, Email: identity.Email, LinkedAt: time.Now()}
+		dbUser = s.db.GetUser(identity.Email, "password")
+		if dbUser != nil {
+			// linking to an unverified local account would hand it to whoever registered it
+			if !dbUser.IsVerified || dbUser.Email != identity.Email {
+				redirectSignInError(w, r, "account_exists")
+				return
+			}
+			if err := s.db.LinkIdentity(dbUser.ID, link); err != nil {
+				redirectSignInError(w, r, "server_error")
+				return
+			}
+		} else {
+			dbUser, err = s.provisionOIDCUser(identity, link)
+			if err != nil {
+				log.Printf("provisioning user from %s failed: %v", provider.Name, err)
+				redirectSignInError(w, r, "server_error")
+				return
+			}
+		}
+	}
+
+	if wait := s.retryAfter(accountThrottleKey(dbUser)); wait > 0 {
+		redirectSignInError(w, r, "locked")
+		return
+	}
+
+	if dbUser.MFAEnabled {
+		mfaToken, err := utils.CreateMFAToken(dbUser.ID.Hex())
+		if err != nil {
+			redirectSignInError(w, r, "server_error")
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     mfaTokenCookie,
+			Value:    mfaToken,
+			Path:     "/api/v1/sign-in/mfa",
+			MaxAge:   int((5 * time.Minute).Seconds()),
+			HttpOnly: true,
+			Secure:   false,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, utils.AppURL()+"/sign-in/mfa", http.StatusFound)
+		return
+	}
+
+	if _, err := s.startSession(w, r, dbUser); errors.Is(err, errAccountSuspended) {
+		redirectSignInError(w, r, "suspended")
+		return
+	} else if err != nil {
+		redirectSignInError(w, r, "server_error")
+		return
+	}
+	http.Redirect(w, r, utils.AppURL()+"/dashboard", http.StatusFound)
+}
+
+func (s *Server) provisionOIDCUser(identity *oidc.Identity, link models.ExternalIdentity) (*models.UserModel, error) {
+	base := identity.PreferredUsername
+	if base == "" {
+		base = strings.Split(identity.Email, "@")[0]
+	}
+	base = sanitizeUsername(base)
+
+	username := ""
+	for attempt := 0; attempt < 10; attempt++ {
+		candidate := base
+		if attempt > 0 {
+			candidate = fmt.Sprintf("%s%04d", base, rand.IntN(10000))
+		}
+		if !utils.IsReservedUsername(candidate) && !s.db.CheckExistingUser(candidate, identity.Email) {
+			username = candidate
+			break
+		}
+	}
+	if username == "" {
+		return nil, fmt.Errorf("no free username for %q", base)
+	}
+
+	// no password is set, these users sign in through their provider or a magic link
+	user := models.UserModel{
+		ID:                  primitive.NewObjectID(),
+		Username:            username,
+		Email:               identity.Email,
+		IsVerified:          true,
+		IsAcceptingMessages: true,
+		Identities:          []models.ExternalIdentity{link},
+	}
+	if _, err := s.db.CreateUser(user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func sanitizeUsername(name string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(name) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_' {
+			b.WriteRune(c)
+		}
+	}
+	username := b.String()
+	if len(username) > 24 {
+		username = username[:24]
+	}
+	if len(username) < 3 {
+		username = "user" + username
+	}
+	return username
+}
+
+func redirectSignInError(w http.ResponseWriter, r *http.Request, code string) {
+	http.Redirect(w, r, utils.AppURL()+"/sign-in?error="+url.QueryEscape(code), http.StatusFound)
+}
@@ -0,0 +1,294 @@
+package server
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"silent-notes/internal/database"
+	"silent-notes/internal/models"
+	"silent-notes/internal/utils"
+	"silent-notes/internal/utils/oidc/oidctest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const testAppURL = "http://app.test"
+
+// fakeDB keeps users in memory. Methods the OAuth flow does not use are left
+// to the embedded nil interface and panic if called.
+type fakeDB struct {
+	database.Service
+
+	mu    sync.Mutex
+	users []*models.UserModel
+}
+
+func (db *fakeDB) find(match func(*models.UserModel) bool) *models.UserModel {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, user := range db.users {
+		if match(user) {
+			copied := *user
+			return &copied
+		}
+	}
+	return nil
+}
+
+func (db *fakeDB) GetUser(identifier, projection string) *models.UserModel {
+	return db.find(func(u *models.UserModel) bool { return u.Email == identifier || u.Username == identifier })
+}
+
+func (db *fakeDB) GetUserByID(userId primitive.ObjectID) *models.UserModel {
+	return db.find(func(u *models.UserModel) bool { return u.ID == userId })
+}
+
+func (db *fakeDB) GetUserByIdentity(provider, subject string) *models.UserModel {
+	return db.find(func(u *models.UserModel) bool {
+		for _, identity := range u.Identities {
+			if identity.Provider == provider && identity.Subject == subject {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (db *fakeDB) CheckExistingUser(username, email string) bool {
+	return db.find(func(u *models.UserModel) bool { return u.Username == username || u.Email == email }) != nil
+}
+
+func (db *fakeDB) CreateUser(user models.UserModel) (interface{}, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.users = append(db.users, &user)
+	return user.ID, nil
+}
+
+func (db *fakeDB) LinkIdentity(userId primitive.ObjectID, identity models.ExternalIdentity) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, user := range db.users {
+		if user.ID == userId {
+			user.Identities = append(user.Identities, identity)
+		}
+	}
+	return nil
+}
+
+func (db *fakeDB) GetAuthThrottle(key string) *models.AuthThrottle         { return nil }
+func (db *fakeDB) ClearAuthFailures(key string) error                      { return nil }
+func (db *fakeDB) IsUserSuspended(userId primitive.ObjectID) (bool, error) { return false, nil }
+func (db *fakeDB) CancelAccountDeletion(userId primitive.ObjectID) error   { return nil }
+func (db *fakeDB) CreateSession(session models.Session) error              { return nil }
+func (db *fakeDB) AddAuditEvent(event models.AuditEvent) error             { return nil }
+func (db *fakeDB) RecordAuthFailure(string, time.Duration) (*models.AuthThrottle, error) {
+	return &models.AuthThrottle{}, nil
+}
+
+var (
+	testIssuer *oidctest.Issuer
+	testAPI    *httptest.Server
+	testDB     = &fakeDB{}
+)
+
+func TestMain(m *testing.M) {
+	testIssuer = oidctest.NewIssuer("silent-notes")
+	testAPI = httptest.NewServer((&Server{db: testDB}).RegisterRoutes())
+
+	os.Setenv("APP_URL", testAppURL)
+	os.Setenv("OIDC_PROVIDERS", "mock")
+	os.Setenv("OIDC_MOCK_ISSUER", testIssuer.URL)
+	os.Setenv("OIDC_MOCK_CLIENT_ID", "silent-notes")
+	os.Setenv("OIDC_MOCK_REDIRECT_URL", testAPI.URL+"/api/v1/oauth/mock/callback")
+	if err := utils.LoadSigningKeys(); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	testAPI.Close()
+	testIssuer.Close()
+	os.Exit(code)
+}
+
+// oauthLogin runs the whole browser round trip and returns where the
+// frontend was sent in the end, with the cookie jar used on the way.
+func oauthLogin(t *testing.T, identity oidctest.Identity) (*url.URL, http.CookieJar) {
+	t.Helper()
+	testIssuer.Identity = identity
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar, CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if strings.HasPrefix(req.URL.String(), testAppURL) {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}}
+
+	resp, err := client.Get(testAPI.URL + "/api/v1/oauth/mock/login")
+	if err != nil {
+		t.Fatalf("oauth login: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("oauth login ended with %d, want a redirect to the app", resp.StatusCode)
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("parsing redirect: %v", err)
+	}
+	return location, jar
+}
+
+func hasSessionCookie(jar http.CookieJar) bool {
+	api, _ := url.Parse(testAPI.URL)
+	for _, cookie := range jar.Cookies(api) {
+		if cookie.Name == "token" && cookie.Value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func TestOAuthProvisionsNewUser(t *testing.T) {
+	location, jar := oauthLogin(t, oidctest.Identity{Subject: "new-1", Email: "new@example.com", EmailVerified: true, PreferredUsername: "New.Person"})
+
+	if location.String() != testAppURL+"/dashboard" {
+		t.Fatalf("redirected to %s, want the dashboard", location)
+	}
+	if !hasSessionCookie(jar) {
+		t.Fatal("no session cookie was set")
+	}
+
+	user := testDB.GetUserByIdentity("mock", "new-1")
+	if user == nil {
+		t.Fatal("no user was provisioned")
+	}
+	if user.Username != "newperson" || user.Email != "new@example.com" || !user.IsVerified || user.Password != "" {
+		t.Fatalf("provisioned user = %+v", user)
+	}
+}
+
+func TestOAuthProvisionsFreeUsername(t *testing.T) {
+	testDB.CreateUser(models.UserModel{ID: primitive.NewObjectID(), Username: "taken", Email: "taken@example.com", IsVerified: true})
+
+	location, _ := oauthLogin(t, oidctest.Identity{Subject: "new-2", Email: "other@example.com", EmailVerified: true, PreferredUsername: "taken"})
+
+	if location.String() != testAppURL+"/dashboard" {
+		t.Fatalf("redirected to %s, want the dashboard", location)
+	}
+	user := testDB.GetUserByIdentity("mock", "new-2")
+	if user == nil || user.Username == "taken" || !strings.HasPrefix(user.Username, "taken") {
+		t.Fatalf("provisioned user = %+v, want a numbered variant of taken", user)
+	}
+}
+
+func TestOAuthLinksVerifiedEmail(t *testing.T) {
+	existing := models.UserModel{ID: primitive.NewObjectID(), Username: "linked", Email: "linked@example.com", Password: "hash", IsVerified: true}
+	testDB.CreateUser(existing)
+
+	location, jar := oauthLogin(t, oidctest.Identity{Subject: "link-1", Email: "linked@example.com", EmailVerified: true})
+
+	if location.String() != testAppURL+"/dashboard" {
+		t.Fatalf("redirected to %s, want the dashboard", location)
+	}
+	if !hasSessionCookie(jar) {
+		t.Fatal("no session cookie was set")
+	}
+	if user := testDB.GetUserByIdentity("mock", "link-1"); user == nil || user.ID != existing.ID {
+		t.Fatalf("identity linked to %+v, want the existing account", user)
+	}
+}
+
+func TestOAuthRefusesUnverifiedEmail(t *testing.T) {
+	location, jar := oauthLogin(t, oidctest.Identity{Subject: "unverified-1", Email: "unverified@example.com"})
+
+	if got := location.Query().Get("error"); got != "email_not_verified" {
+		t.Fatalf("error = %q, want email_not_verified", got)
+	}
+	if hasSessionCookie(jar) || testDB.GetUserByIdentity("mock", "unverified-1") != nil {
+		t.Fatal("an unverified email signed in")
+	}
+}
+
+func TestOAuthRefusesUnverifiedLocalAccount(t *testing.T) {
+	testDB.CreateUser(models.UserModel{ID: primitive.NewObjectID(), Username: "squatter", Email: "victim@example.com", Password: "hash"})
+
+	location, _ := oauthLogin(t, oidctest.Identity{Subject: "victim-1", Email: "victim@example.com", EmailVerified: true})
+
+	if got := location.Query().Get("error"); got != "account_exists" {
+		t.Fatalf("error = %q, want account_exists", got)
+	}
+	if testDB.GetUserByIdentity("mock", "victim-1") != nil {
+		t.Fatal("identity was linked to an unverified account")
+	}
+}
+
+func TestOAuthRejectsStateMismatch(t *testing.T) {
+	testIssuer.Identity = oidctest.Identity{Subject: "state-1", Email: "state@example.com", EmailVerified: true}
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar, CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	// stop at the provider's redirect back and swap the state
+	resp, err := client.Get(testAPI.URL + "/api/v1/oauth/mock/login")
+	if err != nil {
+		t.Fatalf("oauth login: %v", err)
+	}
+	resp.Body.Close()
+	resp, err = client.Get(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("authorization endpoint: %v", err)
+	}
+	resp.Body.Close()
+
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+	query := callback.Query()
+	query.Set("state", "forged")
+	callback.RawQuery = query.Encode()
+
+	resp, err = client.Get(callback.String())
+	if err != nil {
+		t.Fatalf("callback: %v", err)
+	}
+	resp.Body.Close()
+
+	location, _ := url.Parse(resp.Header.Get("Location"))
+	if got := location.Query().Get("error"); got != "invalid_state" {
+		t.Fatalf("error = %q, want invalid_state", got)
+	}
+	if hasSessionCookie(jar) {
+		t.Fatal("a forged state signed in")
+	}
+}
+
+func TestOAuthKeepsMFAChallengeOutOfURL(t *testing.T) {
+	testDB.CreateUser(models.UserModel{
+		ID: primitive.NewObjectID(), Username: "twofactor", Email: "mfa@example.com", IsVerified: true, MFAEnabled: true,
+		Identities: []models.ExternalIdentity{{Provider: "mock", Subject: "mfa-1", Email: "mfa@example.com"}},
+	})
+
+	location, jar := oauthLogin(t, oidctest.Identity{Subject: "mfa-1", Email: "mfa@example.com", EmailVerified: true})
+
+	if location.String() != testAppURL+"/sign-in/mfa" {
+		t.Fatalf("redirected to %s, want the mfa page without a query", location)
+	}
+	if hasSessionCookie(jar) {
+		t.Fatal("signed in before the second factor")
+	}
+	mfa, _ := url.Parse(testAPI.URL + "/api/v1/sign-in/mfa")
+	found := false
+	for _, cookie := range jar.Cookies(mfa) {
+		found = found || (cookie.Name == mfaTokenCookie && cookie.Value != "")
+	}
+	if !found {
+		t.Fatal("no mfa challenge cookie for the mfa endpoint")
+	}
+}
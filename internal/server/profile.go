@@ -0,0 +1,186 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"silent-notes/internal/models"
+	"silent-notes/internal/types"
+	"silent-notes/internal/utils"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetPublicProfile shows senders what they need to know before writing to a
+// user. It never includes the email address.
+func (s *Server) GetPublicProfile(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+
+	user := s.db.GetUser(username, "password")
+	if user == nil || user.Email == username || !user.DeletionScheduledAt.IsZero() {
+		w.WriteHeader(http.StatusNotFound)
+		res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "user not found"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	if suspended, _ := s.db.IsUserSuspended(user.ID); suspended {
+		w.WriteHeader(http.StatusNotFound)
+		res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "user not found"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "public profile", Data: map[string]interface{}{"profile": user.PublicProfile(time.Now())}}
+	json.NewEncoder(w).Encode(res)
+}
+
+// GetProfile returns the signed in user's own profile for editing.
+func (s *Server) GetProfile(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	user := s.db.GetUserByID(userIdObjectId)
+	if user == nil {
+		w.WriteHeader(http.StatusNotFound)
+		res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "user not found"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "profile", Data: map[string]interface{}{
+		"username": user.Username,
+		"profile":  user.Profile,
+	}}
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	var profileData types.ProfileType
+	err = json.NewDecoder(r.Body).Decode(&profileData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid input", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer r.Body.Close()
+
+	var validate = validator.New()
+	err = validate.Struct(profileData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "validation failed", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	profile := models.UserProfile{
+		DisplayName: strings.TrimSpace(profileData.DisplayName),
+		Bio:         strings.TrimSpace(profileData.Bio),
+		PromptText:  strings.TrimSpace(profileData.PromptText),
+	}
+	if err := s.db.UpdateProfile(userIdObjectId, profile); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error updating profile", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "profile updated", Data: map[string]interface{}{"profile": profile}}
+	json.NewEncoder(w).Encode(res)
+}
+
+// SetRetention changes how long the user's messages are kept and applies the
+// new policy to the messages already in the inbox.
+func (s *Server) SetRetention(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	var retentionData types.RetentionType
+	err = json.NewDecoder(r.Body).Decode(&retentionData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid input", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer r.Body.Close()
+
+	var validate = validator.New()
+	err = validate.Struct(retentionData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "validation failed", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	policy := models.RetentionPolicy{AfterDays: retentionData.AfterDays, AfterRead: retentionData.AfterRead}
+
+	// a policy reaching back into the inbox deletes for good, so the user has
+	// to see how many messages go before it is applied
+	expiring, err := s.db.CountExpiringMessages(userIdObjectId, policy, utils.ReadMessageTTL(), time.Now())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	if expiring > 0 && !retentionData.Confirm {
+		w.WriteHeader(http.StatusConflict)
+		res := types.Response{StatusCode: http.StatusConflict, Success: false, Message: "this policy deletes existing messages, send confirm to apply it", Data: map[string]interface{}{"expiring": expiring}}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if err := s.db.SetRetentionPolicy(userIdObjectId, policy); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error updating retention policy", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	if err := s.db.ApplyRetentionPolicy(userIdObjectId, policy, utils.ReadMessageTTL()); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error applying retention policy", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	// cards are not cached for expiring messages, drop the ones that now are
+	if policy.AfterDays > 0 || policy.AfterRead {
+		if err := s.store.DeletePrefix(r.Context(), "cards/"+userId+"/"); err != nil {
+			log.Printf("error removing cards of user %s: %v", userId, err)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "retention policy updated", Data: map[string]interface{}{"retention": policy, "expiring": expiring}}
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"silent-notes/internal/database"
+	"silent-notes/internal/models"
+	"silent-notes/internal/types"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// slugs end up in share links, keep them to lowercase words joined by dashes
+var promptSlugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+func (s *Server) GetPrompts(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	user := s.db.GetUserByID(userIdObjectId)
+	if user == nil {
+		w.WriteHeader(http.StatusNotFound)
+		res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "user not found"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	prompts := user.Prompts
+	if prompts == nil {
+		prompts = []models.Prompt{}
+	}
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "prompts", Data: map[string]interface{}{"prompts": prompts}}
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) CreatePrompt(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	promptData, ok := decodePrompt(w, r)
+	if !ok {
+		return
+	}
+
+	prompt := models.Prompt{
+		ID:          primitive.NewObjectID(),
+		Slug:        promptData.Slug,
+		Title:       promptData.Title,
+		Description: promptData.Description,
+		IsOpen:      promptData.IsOpen == nil || *promptData.IsOpen,
+		MaxMessages: promptData.MaxMessages,
+		CreatedAt:   time.Now(),
+	}
+	err = s.db.AddPrompt(userIdObjectId, prompt)
+	if err != nil {
+		if errors.Is(err, database.ErrPromptConflict) {
+			w.WriteHeader(http.StatusConflict)
+			res := types.Response{StatusCode: http.StatusConflict, Success: false, Message: "slug already used or too many prompts", Error: err.Error()}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error creating prompt", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	res := types.Response{StatusCode: http.StatusCreated, Success: true, Message: "prompt created", Data: map[string]interface{}{"prompt": prompt}}
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) UpdatePrompt(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	promptId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "promptId"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid prompt id", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	promptData, ok := decodePrompt(w, r)
+	if !ok {
+		return
+	}
+
+	user := s.db.GetUserByID(userIdObjectId)
+	var prompt *models.Prompt
+	if user != nil {
+		for i := range user.Prompts {
+			if user.Prompts[i].ID == promptId {
+				prompt = &user.Prompts[i]
+			}
+		}
+	}
+	if prompt == nil {
+		w.WriteHeader(http.StatusNotFound)
+		res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "prompt not found"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	prompt.Slug = promptData.Slug
+	prompt.Title = promptData.Title
+	prompt.Description = promptData.Description
+	prompt.MaxMessages = promptData.MaxMessages
+	if promptData.IsOpen != nil {
+		prompt.IsOpen = *promptData.IsOpen
+	}
+	err = s.db.UpdatePrompt(userIdObjectId, *prompt)
+	if err != nil {
+		if errors.Is(err, database.ErrPromptNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "prompt not found"}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		if errors.Is(err, database.ErrPromptConflict) {
+			w.WriteHeader(http.StatusConflict)
+			res := types.Response{StatusCode: http.StatusConflict, Success: false, Message: "slug already used", Error: err.Error()}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error updating prompt", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "prompt updated", Data: map[string]interface{}{"prompt": prompt}}
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) DeletePrompt(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	promptId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "promptId"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid prompt id", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	err = s.db.DeletePrompt(userIdObjectId, promptId)
+	if err != nil {
+		if errors.Is(err, database.ErrPromptNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "prompt not found"}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error deleting prompt", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "prompt deleted, its messages stay in your inbox"}
+	json.NewEncoder(w).Encode(res)
+}
+
+// decodePrompt reads and validates a prompt body, writing the error response
+// itself when it is not usable.
+func decodePrompt(w http.ResponseWriter, r *http.Request) (types.PromptType, bool) {
+	var promptData types.PromptType
+	err := json.NewDecoder(r.Body).Decode(&promptData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid input", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return promptData, false
+	}
+	defer r.Body.Close()
+
+	var validate = validator.New()
+	err = validate.Struct(promptData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "validation failed", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return promptData, false
+	}
+
+	if !promptSlugPattern.MatchString(promptData.Slug) {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "slug can only contain lowercase letters, digits and dashes"}
+		json.NewEncoder(w).Encode(res)
+		return promptData, false
+	}
+	return promptData, true
+}
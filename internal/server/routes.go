@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"os"
 	"silent-notes/internal/middlewares"
+	"silent-notes/internal/models"
+	"silent-notes/internal/utils"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -20,25 +22,99 @@ func (s *Server) RegisterRoutes() http.Handler {
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
 		AllowCredentials: true,
 	}))
-	r.Use(middleware.AllowContentType("application/json", "text/xml"))
+	r.Use(middleware.AllowContentType("application/json", "text/xml", "multipart/form-data"))
 	r.Use(middleware.CleanPath)
+	r.Use(middleware.RequestID)
 	r.Use(middleware.Logger)
 
 	r.Get("/", s.HelloWorldHandler)
 	r.Get("/health", s.healthHandler)
+	r.Get("/.well-known/jwks.json", s.JWKSHandler)
 
 	r.Route("/api/v1", func(r chi.Router) {
 		r.Post("/sign-up", s.SignUp)
 		r.Post("/sign-in", s.SignIn)
+		r.Post("/sign-in/mfa", s.SignInMFA)
+		r.Post("/magic-link", s.RequestMagicLink)
+		r.Post("/magic-link/callback", s.MagicLinkCallback)
+		r.Get("/oauth/{provider}/login", s.OAuthLogin)
+		r.Get("/oauth/{provider}/callback", s.OAuthCallback)
 		r.Put("/verify", s.VerifyUser)
 		r.Post("/send-message", s.SendMessage)
+		r.Post("/thread", s.GetSenderThread)
+		r.Post("/thread/follow-up", s.SendFollowUp)
+		r.Get("/users/{username}", s.GetPublicProfile)
+		r.Get("/users/{username}/resolve", s.ResolveUsername)
+		r.Get("/exports/{exportId}/download", s.DownloadDataExport)
+		r.Get("/avatars/{userId}/{version}/{file}", s.ServeAvatar)
 
 		r.Group(func(r chi.Router) {
-			r.Use(middlewares.Auth)
+			r.Use(middlewares.Auth(s.db))
 			r.Post("/sign-out", s.SignOut)
 			r.Put("/accept-messages", s.AcceptMessages)
 			r.Get("/get-messages", s.GetMessages)
 			r.Delete("/delete-message/{mId}", s.DeleteMessage)
+			r.Get("/messages/export", s.ExportMessages)
+			r.Post("/messages/bulk", s.BulkMessages)
+			r.Get("/messages/search", s.SearchMessages)
+			r.Get("/messages/trash", s.GetTrash)
+			r.Delete("/messages/trash", s.EmptyTrash)
+			r.Post("/messages/{mId}/restore", s.RestoreMessage)
+			r.Get("/messages/{mId}/card", s.MessageCard)
+			r.Put("/messages/{mId}/reply", s.ReplyToMessage)
+			r.Delete("/messages/{mId}/reply", s.DeleteReply)
+			r.Post("/messages/{mId}/block", s.BlockSender)
+			r.Post("/messages/{mId}/report", s.ReportMessage)
+			r.Get("/blocks", s.GetBlocks)
+			r.Delete("/blocks/{blockId}", s.UnblockSender)
+			r.Get("/prompts", s.GetPrompts)
+			r.Post("/prompts", s.CreatePrompt)
+			r.Put("/prompts/{promptId}", s.UpdatePrompt)
+			r.Delete("/prompts/{promptId}", s.DeletePrompt)
+			r.Post("/mfa/enroll", s.EnrollMFA)
+			r.Post("/mfa/confirm", s.ConfirmMFA)
+			r.Post("/mfa/disable", s.DisableMFA)
+			r.Post("/mfa/recovery-codes", s.RegenerateRecoveryCodes)
+			r.Put("/account/password", s.ChangePassword)
+			r.Put("/account/email", s.ChangeEmail)
+			r.Put("/account/username", s.ChangeUsername)
+			r.Get("/account/profile", s.GetProfile)
+			r.Put("/account/profile", s.UpdateProfile)
+			r.Put("/account/avatar", s.UploadAvatar)
+			r.Delete("/account/avatar", s.DeleteAvatar)
+			r.Put("/account/retention", s.SetRetention)
+			r.Put("/account/schedule", s.SetAcceptSchedule)
+			r.Delete("/account/schedule", s.ClearAcceptSchedule)
+			r.Get("/account/share", s.GetShareLink)
+			r.Get("/account/audit", s.GetAuditLog)
+			r.Get("/account/sessions", s.GetSessions)
+			r.Delete("/account/sessions", s.RevokeOtherSessions)
+			r.Delete("/account/sessions/{sessionId}", s.RevokeSession)
+			r.Post("/account/delete", s.DeleteAccount)
+			r.Post("/account/export", s.RequestDataExport)
+			r.Get("/account/export/{exportId}", s.GetDataExport)
+		})
+
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(middlewares.Auth(s.db))
+			can := func(permission models.Permission) func(http.Handler) http.Handler {
+				return middlewares.Authorize(s.db, permission)
+			}
+			r.With(can(models.PermReviewReports)).Get("/reports", s.GetReports)
+			r.With(can(models.PermReviewReports)).Get("/reports/{reportId}", s.GetReport)
+			r.With(can(models.PermReviewReports)).Post("/reports/{reportId}/dismiss", s.DismissReport)
+			r.With(can(models.PermReviewReports)).Post("/reports/{reportId}/remove", s.RemoveReportedMessage)
+			r.With(can(models.PermManageSuspension)).Get("/suspensions", s.GetSuspensions)
+			r.With(can(models.PermManageSuspension)).Post("/suspensions", s.CreateSuspension)
+			r.With(can(models.PermManageSuspension)).Delete("/suspensions/{suspensionId}", s.LiftSuspension)
+			r.With(can(models.PermReadModeration)).Get("/moderation-log", s.GetModerationLog)
+			r.With(can(models.PermReadUsers)).Get("/users", s.ListUsers)
+			r.With(can(models.PermReadUsers)).Get("/users/{userId}", s.GetUserAdmin)
+			r.With(can(models.PermManageSuspension)).Post("/users/{userId}/suspend", s.SuspendUser)
+			r.With(can(models.PermManageUsers)).Post("/users/{userId}/resend-verification", s.ResendVerification)
+			r.With(can(models.PermManageRoles)).Put("/users/{userId}/role", s.SetUserRole)
+			r.With(can(models.PermReadStats)).Get("/stats", s.GetStats)
+			r.With(can(models.PermReadAudit)).Get("/audit", s.GetAuditEvents)
 		})
 	})
 
@@ -57,6 +133,12 @@ func (s *Server) HelloWorldHandler(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(jsonResp)
 }
 
+func (s *Server) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(utils.PublicJWKS())
+}
+
 func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
 	jsonResp, _ := json.Marshal(s.db.Health())
 	_, _ = w.Write(jsonResp)
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"silent-notes/internal/models"
+	"silent-notes/internal/types"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SetAcceptSchedule opens the inbox only inside weekly windows and optionally
+// closes it after a number of messages or at a deadline. The manual
+// is_accepting_messages switch still has the last word.
+func (s *Server) SetAcceptSchedule(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	var scheduleData types.AcceptScheduleType
+	err = json.NewDecoder(r.Body).Decode(&scheduleData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid input", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer r.Body.Close()
+
+	var validate = validator.New()
+	err = validate.Struct(scheduleData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "validation failed", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if scheduleData.CloseAt != nil && !scheduleData.CloseAt.After(time.Now()) {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "close_at must be in the future"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	schedule := &models.AcceptSchedule{
+		Timezone:    scheduleData.Timezone,
+		MaxMessages: scheduleData.MaxMessages,
+		CloseAt:     scheduleData.CloseAt,
+	}
+	for _, window := range scheduleData.Windows {
+		schedule.Windows = append(schedule.Windows, models.ScheduleWindow{Days: window.Days, Start: window.Start, End: window.End})
+	}
+
+	if err := s.db.SetAcceptSchedule(userIdObjectId, schedule); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error saving schedule", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	open, opensAt := schedule.IsOpen(time.Now())
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "schedule updated", Data: map[string]interface{}{
+		"schedule": schedule,
+		"open":     open,
+		"opens_at": opensAt,
+	}}
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) ClearAcceptSchedule(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if err := s.db.SetAcceptSchedule(userIdObjectId, nil); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error removing schedule", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "schedule removed"}
+	json.NewEncoder(w).Encode(res)
+}
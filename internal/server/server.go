@@ -2,6 +2,7 @@ package server
 
 import (
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"strconv"
@@ -10,22 +11,39 @@ import (
 	_ "github.com/joho/godotenv/autoload"
 
 	"silent-notes/internal/database"
+	"silent-notes/internal/storage"
+	"silent-notes/internal/utils"
 )
 
 type Server struct {
 	port int
 
-	db database.Service
+	db    database.Service
+	store storage.Store
 }
 
 func NewServer() *http.Server {
 	port, _ := strconv.Atoi(os.Getenv("PORT"))
+
+	if err := utils.LoadSigningKeys(); err != nil {
+		log.Fatalf("cannot load jwt signing keys: %v", err)
+	}
+
+	store, err := storage.New()
+	if err != nil {
+		log.Fatalf("cannot open blob storage: %v", err)
+	}
+
 	NewServer := &Server{
 		port: port,
 
-		db: database.New(),
+		db:    database.New(),
+		store: store,
 	}
 
+	NewServer.bootstrapAdmins()
+	NewServer.startJobs()
+
 	// Declare Server config
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%d", NewServer.port),
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"silent-notes/internal/database"
+	"silent-notes/internal/models"
+	"silent-notes/internal/types"
+	"silent-notes/internal/utils"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// createSession stores the device a user is signing in from, the session
+// token carries its id.
+func (s *Server) createSession(r *http.Request, userId primitive.ObjectID) (*models.Session, error) {
+	now := time.Now()
+	ua := userAgent(r)
+	session := models.Session{
+		ID:         primitive.NewObjectID(),
+		UserID:     userId,
+		Device:     utils.DeviceName(ua),
+		UserAgent:  ua,
+		IP:         utils.ClientIP(r),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(utils.TokenTTL),
+	}
+	if err := s.db.CreateSession(session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetSessions lists where the user is signed in, the session making the
+// request is marked current.
+func (s *Server) GetSessions(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	sessions, err := s.db.GetSessions(userIdObjectId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	current := r.Context().Value(types.SessionIDKey).(string)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID.Hex() == current
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "sessions", Data: map[string]interface{}{"sessions": sessions}}
+	json.NewEncoder(w).Encode(res)
+}
+
+// RevokeSession signs one device out. Revoking the current session works
+// like signing out.
+func (s *Server) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	sessionId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "sessionId"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid session id", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	err = s.db.DeleteSession(userIdObjectId, sessionId)
+	if err != nil {
+		if errors.Is(err, database.ErrSessionNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "session not found", Error: err.Error()}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	s.audit(r, userIdObjectId, models.AuditSessionRevoked, map[string]string{"session_id": sessionId.Hex()})
+
+	if sessionId.Hex() == r.Context().Value(types.SessionIDKey).(string) {
+		http.SetCookie(w, &http.Cookie{
+			Name:     "token",
+			Value:    "",
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   false,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "session revoked"}
+	json.NewEncoder(w).Encode(res)
+}
+
+// RevokeOtherSessions signs out every device but the one making the request.
+func (s *Server) RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	current, err := primitive.ObjectIDFromHex(r.Context().Value(types.SessionIDKey).(string))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	revoked, err := s.db.DeleteSessions(userIdObjectId, current)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	s.audit(r, userIdObjectId, models.AuditSessionsRevoked, map[string]string{"count": strconv.FormatInt(revoked, 10)})
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "other sessions revoked", Data: map[string]interface{}{"revoked": revoked}}
+	json.NewEncoder(w).Encode(res)
+}
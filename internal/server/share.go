@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"silent-notes/internal/types"
+	"silent-notes/internal/utils"
+	"strconv"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// utm tags are kept short and plain so they group cleanly in analytics
+var shareTagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,31}$`)
+
+const (
+	defaultQRSize = 512
+	maxQRSize     = 2048
+)
+
+// GetShareLink returns the link senders use to reach the user, or one of
+// their prompts with ?prompt=, and the same link as a QR code in PNG and SVG.
+// ?source= tags both with utm_source, the QR code also gets utm_medium=qr so
+// scans can be told apart from clicks.
+func (s *Server) GetShareLink(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	query := r.URL.Query()
+	source := strings.ToLower(strings.TrimSpace(query.Get("source")))
+	if source != "" && !shareTagPattern.MatchString(source) {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "source must be up to 32 letters, digits, dots, dashes or underscores"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	size := defaultQRSize
+	if raw := query.Get("size"); raw != "" {
+		size, err = strconv.Atoi(raw)
+		if err != nil || size < 128 || size > maxQRSize {
+			w.WriteHeader(http.StatusBadRequest)
+			res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "size must be between 128 and 2048 pixels"}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+	}
+
+	user := s.db.GetUserByID(userIdObjectId)
+	if user == nil {
+		w.WriteHeader(http.StatusNotFound)
+		res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "user not found"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	slug := query.Get("prompt")
+	if slug != "" && user.FindPrompt(slug) == nil {
+		w.WriteHeader(http.StatusNotFound)
+		res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "prompt not found"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	medium := ""
+	if source != "" {
+		medium = "link"
+	}
+	link := utils.ShareURL(user.Username, slug, source, medium)
+	qrLink := utils.ShareURL(user.Username, slug, source, "qr")
+
+	png, err := qrcode.Encode(qrLink, qrcode.Medium, size)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error generating qr code", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	svg, err := utils.QRCodeSVG(qrLink)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error generating qr code", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "share link", Data: map[string]interface{}{
+		"url":    link,
+		"qr_url": qrLink,
+		"qr_png": "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+		"qr_svg": svg,
+	}}
+	json.NewEncoder(w).Encode(res)
+}
+
+// shareTag cleans a utm tag a sender's client passed along, anything odd is
+// dropped rather than failing the message.
+func shareTag(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if !shareTagPattern.MatchString(tag) {
+		return ""
+	}
+	return tag
+}
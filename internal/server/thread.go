@@ -0,0 +1,242 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"silent-notes/internal/database"
+	"silent-notes/internal/models"
+	"silent-notes/internal/types"
+	"silent-notes/internal/utils"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ReplyToMessage sets or replaces the owner's answer, the sender can read it
+// with the token they got when sending.
+func (s *Server) ReplyToMessage(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	messageId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "mId"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid message id", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	var replyData types.MessageReplyType
+	err = json.NewDecoder(r.Body).Decode(&replyData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid input", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer r.Body.Close()
+
+	replyData.Content = strings.TrimSpace(replyData.Content)
+	var validate = validator.New()
+	err = validate.Struct(replyData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "validation failed", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	reply := models.MessageReply{Content: replyData.Content, CreatedAt: time.Now()}
+	err = s.db.SetMessageReply(userIdObjectId, messageId, &reply)
+	if err != nil {
+		if errors.Is(err, database.ErrMessageNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "message not found", Error: err.Error()}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "reply saved", Data: map[string]interface{}{"reply": reply}}
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) DeleteReply(w http.ResponseWriter, r *http.Request) {
+	userId := r.Context().Value(types.UserIDKey).(string)
+	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	messageId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "mId"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid message id", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	err = s.db.SetMessageReply(userIdObjectId, messageId, nil)
+	if err != nil {
+		if errors.Is(err, database.ErrMessageNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "message not found", Error: err.Error()}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "reply removed"}
+	json.NewEncoder(w).Encode(res)
+}
+
+// GetSenderThread lets an anonymous sender check on their message with the
+// token from SendMessage. Unknown, trashed and expired messages all look the
+// same so the token reveals nothing once the owner is done with it.
+func (s *Server) GetSenderThread(w http.ResponseWriter, r *http.Request) {
+	var threadData types.SenderThreadType
+	err := json.NewDecoder(r.Body).Decode(&threadData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid input", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer r.Body.Close()
+
+	var validate = validator.New()
+	err = validate.Struct(threadData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "validation failed", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	message, err := s.db.GetMessageBySenderToken(utils.HashToken(threadData.Token))
+	if err != nil {
+		if errors.Is(err, database.ErrMessageNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "message not found"}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "thread", Data: map[string]interface{}{"thread": message.SenderThread()}}
+	json.NewEncoder(w).Encode(res)
+}
+
+// SendFollowUp adds the sender's single follow-up once the owner has replied.
+func (s *Server) SendFollowUp(w http.ResponseWriter, r *http.Request) {
+	var followUpData types.FollowUpType
+	err := json.NewDecoder(r.Body).Decode(&followUpData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid input", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	defer r.Body.Close()
+
+	followUpData.Content = strings.TrimSpace(followUpData.Content)
+	var validate = validator.New()
+	err = validate.Struct(followUpData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "validation failed", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	tokenHash := utils.HashToken(followUpData.Token)
+	message, err := s.db.GetMessageBySenderToken(tokenHash)
+	if err != nil {
+		if errors.Is(err, database.ErrMessageNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "message not found"}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if message.Reply == nil {
+		w.WriteHeader(http.StatusConflict)
+		res := types.Response{StatusCode: http.StatusConflict, Success: false, Message: "the message has not been answered yet"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	followUp := models.MessageReply{Content: followUpData.Content, CreatedAt: time.Now()}
+
+	// a blocked sender is told the follow-up went through, like SendMessage does
+	fingerprints := utils.SenderFingerprints(r, time.Now())
+	if message.Fingerprint != "" {
+		fingerprints = append(fingerprints, message.Fingerprint)
+	}
+	blocked, err := s.db.IsSenderBlocked(message.UserID, fingerprints)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	if blocked {
+		message.FollowUp = &followUp
+		w.WriteHeader(http.StatusCreated)
+		res := types.Response{StatusCode: http.StatusCreated, Success: true, Message: "follow-up sent", Data: map[string]interface{}{"thread": message.SenderThread()}}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	err = s.db.AddFollowUp(tokenHash, followUp)
+	if err != nil {
+		if errors.Is(err, database.ErrFollowUpExists) {
+			w.WriteHeader(http.StatusConflict)
+			res := types.Response{StatusCode: http.StatusConflict, Success: false, Message: "a follow-up has already been sent"}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	message.FollowUp = &followUp
+	w.WriteHeader(http.StatusCreated)
+	res := types.Response{StatusCode: http.StatusCreated, Success: true, Message: "follow-up sent", Data: map[string]interface{}{"thread": message.SenderThread()}}
+	json.NewEncoder(w).Encode(res)
+}
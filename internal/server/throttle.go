@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"silent-notes/internal/models"
+	"silent-notes/internal/types"
+	"silent-notes/internal/utils"
+	"silent-notes/internal/utils/email"
+	"strconv"
+	"time"
+)
+
+const (
+	failureWindow = 15 * time.Minute
+	// failures allowed before each further attempt has to wait
+	freeAttempts    = 3
+	maxAttemptDelay = time.Minute
+
+	accountLockoutThreshold = 10
+	accountLockoutDuration  = 15 * time.Minute
+	ipLockoutThreshold      = 50
+	ipLockoutDuration       = 15 * time.Minute
+
+	// a verify code is thrown away after this many wrong guesses
+	maxVerifyAttempts = 5
+
+	// sign-in links one account or address can have sent per failure window
+	magicLinkAccountLimit = 5
+	magicLinkIPLimit      = 20
+	magicLinkLockout      = time.Hour
+)
+
+func accountThrottleKey(user *models.UserModel) string {
+	return "account:" + user.ID.Hex()
+}
+
+func ipThrottleKey(r *http.Request) string {
+	return "ip:" + utils.ClientIP(r)
+}
+
+// magicLinkThrottleKey counts sign-in link emails apart from failed sign-ins,
+// so asking for links never locks out the password.
+func magicLinkThrottleKey(key string) string {
+	return "magic_link:" + key
+}
+
+// retryAfter reports how long the caller must wait before key may try again,
+// either because it is locked out or because of the progressive delay.
+func (s *Server) retryAfter(key string) time.Duration {
+	throttle := s.db.GetAuthThrottle(key)
+	if throttle == nil {
+		return 0
+	}
+
+	now := time.Now()
+	if now.Before(throttle.LockedUntil) {
+		return throttle.LockedUntil.Sub(now)
+	}
+	if throttle.Failures < freeAttempts || now.Sub(throttle.LastFailureAt) > failureWindow {
+		return 0
+	}
+
+	delay := time.Duration(math.Pow(2, float64(throttle.Failures-freeAttempts))) * time.Second
+	if delay > maxAttemptDelay {
+		delay = maxAttemptDelay
+	}
+	if wait := throttle.LastFailureAt.Add(delay).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// recordFailure counts a failed attempt against key and locks it once the
+// threshold is reached. It reports whether this failure caused the lock.
+func (s *Server) recordFailure(key string, threshold int, lockout time.Duration) (time.Time, bool) {
+	throttle, err := s.db.RecordAuthFailure(key, failureWindow)
+	if err != nil {
+		log.Printf("error recording auth failure for %s: %v", key, err)
+		return time.Time{}, false
+	}
+	if throttle.Failures != threshold {
+		return time.Time{}, false
+	}
+
+	lockedUntil := time.Now().Add(lockout)
+	if err := s.db.LockAuthKey(key, lockedUntil); err != nil {
+		log.Printf("error locking %s: %v", key, err)
+		return time.Time{}, false
+	}
+	return lockedUntil, true
+}
+
+// recordSignInFailure counts a wrong password or second factor against both
+// the account and the caller's address, and mails the owner on lockout.
+// Failures on an existing account go into its audit trail along with the
+// path, which tells a sign-in from a re-authentication.
+func (s *Server) recordSignInFailure(r *http.Request, user *models.UserModel) {
+	s.recordFailure(ipThrottleKey(r), ipLockoutThreshold, ipLockoutDuration)
+	if user == nil {
+		return
+	}
+	s.audit(r, user.ID, models.AuditSignInFailed, map[string]string{"path": r.URL.Path})
+
+	lockedUntil, locked := s.recordFailure(accountThrottleKey(user), accountLockoutThreshold, accountLockoutDuration)
+	if locked {
+		go func() {
+			if err := email.SendLockoutEmail(user.Username, user.Email, lockedUntil); err != nil {
+				log.Printf("error sending lockout email: %v", err)
+			}
+		}()
+	}
+}
+
+func writeTooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(http.StatusTooManyRequests)
+	res := types.Response{StatusCode: http.StatusTooManyRequests, Success: false, Message: "too many attempts, try again later", Data: map[string]interface{}{"retry_after": seconds}}
+	json.NewEncoder(w).Encode(res)
+}
@@ -2,8 +2,11 @@ package server
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
+	"log"
 	"net/http"
+	"regexp"
+	"silent-notes/internal/database"
 	"silent-notes/internal/models"
 	"silent-notes/internal/types"
 	"silent-notes/internal/utils"
@@ -17,12 +20,16 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// usernames are shown to senders, keep them to one lowercase alphabet so an
+// account cannot pass itself off as another with lookalike characters
+var usernamePattern = regexp.MustCompile(`^[a-z0-9_]{3,30}$`)
+
 func (s *Server) SignUp(w http.ResponseWriter, r *http.Request) {
 
 	var wg sync.WaitGroup
 
-	var user models.UserModel
-	err := json.NewDecoder(r.Body).Decode(&user)
+	var signUpData types.SignUpType
+	err := json.NewDecoder(r.Body).Decode(&signUpData)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid input", Error: err.Error()}
@@ -32,7 +39,7 @@ func (s *Server) SignUp(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	var validate = validator.New()
-	err = validate.Struct(user)
+	err = validate.Struct(signUpData)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "validation failed", Error: err.Error()}
@@ -40,7 +47,21 @@ func (s *Server) SignUp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	existingUser := s.db.CheckExistingUser(user.Username, user.Email)
+	if !usernamePattern.MatchString(signUpData.Username) {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "username can only contain lowercase letters, digits and underscores"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if utils.IsReservedUsername(signUpData.Username) {
+		w.WriteHeader(http.StatusBadRequest)
+		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "username is reserved"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	existingUser := s.db.CheckExistingUser(signUpData.Username, signUpData.Email)
 	if existingUser {
 		w.WriteHeader(http.StatusConflict)
 		res := types.Response{StatusCode: http.StatusConflict, Success: false, Message: "username/email already taken"}
@@ -48,7 +69,7 @@ func (s *Server) SignUp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hashedPassword, err := utils.HashPassword(user.Password)
+	user, err := newSignUpUser(signUpData)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
@@ -56,13 +77,6 @@ func (s *Server) SignUp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user.ID = primitive.NewObjectID()
-	user.Password = string(hashedPassword)
-	user.IsAcceptingMessages = true
-	user.IsVerified = false
-	user.VerifyCode = utils.GenerateVerifyCode()
-	user.VerifyCodeExpiry = utils.VerifyCodeExpiry()
-
 	wg.Add(1)
 
 	var emailError error
@@ -91,6 +105,25 @@ func (s *Server) SignUp(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(res)
 
 }
+
+// newSignUpUser builds the account field by field, nothing the client sent
+// besides the sign-up form ends up on it.
+func newSignUpUser(data types.SignUpType) (models.UserModel, error) {
+	hashedPassword, err := utils.HashPassword(data.Password)
+	if err != nil {
+		return models.UserModel{}, err
+	}
+	return models.UserModel{
+		ID:                  primitive.NewObjectID(),
+		Username:            data.Username,
+		Email:               data.Email,
+		Password:            string(hashedPassword),
+		IsAcceptingMessages: true,
+		VerifyCode:          utils.GenerateVerifyCode(),
+		VerifyCodeExpiry:    utils.VerifyCodeExpiry(),
+	}, nil
+}
+
 func (s *Server) SignIn(w http.ResponseWriter, r *http.Request) {
 
 	var wg sync.WaitGroup
@@ -115,17 +148,29 @@ func (s *Server) SignIn(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if wait := s.retryAfter(ipThrottleKey(r)); wait > 0 {
+		writeTooManyAttempts(w, wait)
+		return
+	}
+
 	dbUser := s.db.GetUser(user.Identifier, "")
 
 	if dbUser == nil {
+		s.recordSignInFailure(r, nil)
 		w.WriteHeader(http.StatusNotFound)
 		res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "user not found"}
 		json.NewEncoder(w).Encode(res)
 		return
 	}
 
+	if wait := s.retryAfter(accountThrottleKey(dbUser)); wait > 0 {
+		writeTooManyAttempts(w, wait)
+		return
+	}
+
 	isPasswordCorrect := utils.CheckPassword(user.Password, dbUser.Password)
 	if !isPasswordCorrect {
+		s.recordSignInFailure(r, dbUser)
 		w.WriteHeader(http.StatusBadRequest)
 		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "wrong credentials", Error: "wrong credentials"}
 		json.NewEncoder(w).Encode(res)
@@ -159,15 +204,92 @@ func (s *Server) SignIn(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token := utils.CreateJWT(dbUser.ID.Hex())
+	s.beginSession(w, r, dbUser)
+}
 
-	if token == nil {
+// beginSession signs in a user whose first factor checked out, handing out an
+// mfa challenge instead of the session when the account has two-factor enabled.
+func (s *Server) beginSession(w http.ResponseWriter, r *http.Request, dbUser *models.UserModel) {
+	if dbUser.MFAEnabled {
+		mfaToken, err := utils.CreateMFAToken(dbUser.ID.Hex())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error creating mfa challenge", Error: err.Error()}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "second factor required", Data: map[string]interface{}{"mfa_required": true, "mfa_token": mfaToken}}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	s.completeSignIn(w, r, dbUser)
+}
+
+// completeSignIn issues the session cookie for a user who passed every sign-in check.
+func (s *Server) completeSignIn(w http.ResponseWriter, r *http.Request, dbUser *models.UserModel) {
+	token, err := s.startSession(w, r, dbUser)
+	if errors.Is(err, errAccountSuspended) {
+		w.WriteHeader(http.StatusForbidden)
+		res := types.Response{StatusCode: http.StatusForbidden, Success: false, Message: "this account has been suspended"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error creating jwt token"}
 		json.NewEncoder(w).Encode(res)
 		return
 	}
 
+	w.WriteHeader(http.StatusOK)
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "user signed in successfully", Data: map[string]interface{}{"token": token, "user": map[string]interface{}{ // Explicitly define this as a map
+		"id":                    dbUser.ID,
+		"username":              dbUser.Username,
+		"email":                 dbUser.Email,
+		"is_verified":           dbUser.IsVerified,
+		"is_accepting_messages": dbUser.IsAcceptingMessages,
+		"mfa_enabled":           dbUser.MFAEnabled,
+		"role":                  dbUser.EffectiveRole(),
+	}, "deletion_cancelled": !dbUser.DeletionScheduledAt.IsZero()}}
+	json.NewEncoder(w).Encode(res)
+}
+
+var errAccountSuspended = errors.New("account suspended")
+
+// startSession mints the session token and sets it as the auth cookie.
+// Every way of signing in ends here, so it is where suspended accounts are
+// turned away.
+func (s *Server) startSession(w http.ResponseWriter, r *http.Request, dbUser *models.UserModel) (string, error) {
+	suspended, err := s.db.IsUserSuspended(dbUser.ID)
+	if err != nil {
+		return "", err
+	}
+	if suspended {
+		s.audit(r, dbUser.ID, models.AuditSignInFailed, map[string]string{"reason": "suspended"})
+		return "", errAccountSuspended
+	}
+
+	s.db.ClearAuthFailures(accountThrottleKey(dbUser))
+
+	// signing in is how a pending account deletion gets cancelled
+	if !dbUser.DeletionScheduledAt.IsZero() {
+		if err := s.db.CancelAccountDeletion(dbUser.ID); err != nil {
+			return "", err
+		}
+		s.audit(r, dbUser.ID, models.AuditDeletionCancelled, nil)
+	}
+
+	session, err := s.createSession(r, dbUser.ID)
+	if err != nil {
+		return "", err
+	}
+	token := utils.CreateJWT(dbUser.ID.Hex(), session.ID.Hex(), session.ExpiresAt)
+	if token == nil {
+		return "", errors.New("error creating jwt token")
+	}
+
 	cookie := &http.Cookie{
 		Name:     "token",
 		Value:    token.(string),
@@ -176,22 +298,22 @@ func (s *Server) SignIn(w http.ResponseWriter, r *http.Request) {
 		Secure:   false,
 		SameSite: http.SameSiteLaxMode,
 	}
-	fmt.Printf("user db: %+v\n", dbUser)
 	http.SetCookie(w, cookie)
-	w.WriteHeader(http.StatusOK)
-	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "user signed in successfully", Data: map[string]interface{}{"token": token, "user": map[string]interface{}{ // Explicitly define this as a map
-		"id":                    dbUser.ID,
-		"username":              dbUser.Username,
-		"email":                 dbUser.Email,
-		"is_verified":           dbUser.IsVerified,
-		"is_accepting_messages": dbUser.IsAcceptingMessages,
-	}}}
-	json.NewEncoder(w).Encode(res)
+	s.audit(r, dbUser.ID, models.AuditSignIn, map[string]string{"session_id": session.ID.Hex(), "device": session.Device})
+	return token.(string), nil
 }
 
 func (s *Server) SignOut(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
+	if userId, err := primitive.ObjectIDFromHex(r.Context().Value(types.UserIDKey).(string)); err == nil {
+		sessionId, _ := primitive.ObjectIDFromHex(r.Context().Value(types.SessionIDKey).(string))
+		if err := s.db.DeleteSession(userId, sessionId); err != nil && !errors.Is(err, database.ErrSessionNotFound) {
+			log.Printf("error ending session %s: %v", sessionId.Hex(), err)
+		}
+		s.audit(r, userId, models.AuditSignOut, nil)
+	}
+
 	cookie := http.Cookie{
 		Name:     "token",
 		Value:    "",
@@ -228,8 +350,14 @@ func (s *Server) VerifyUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if wait := s.retryAfter(ipThrottleKey(r)); wait > 0 {
+		writeTooManyAttempts(w, wait)
+		return
+	}
+
 	user := s.db.GetUser(username, "password")
 	if user == nil {
+		s.recordFailure(ipThrottleKey(r), ipLockoutThreshold, ipLockoutDuration)
 		w.WriteHeader(http.StatusNotFound)
 		res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "user not found"}
 		json.NewEncoder(w).Encode(res)
@@ -247,18 +375,48 @@ func (s *Server) VerifyUser(w http.ResponseWriter, r *http.Request) {
 	isCorrectVerifyCode := verifyCodeInt == user.VerifyCode
 
 	if !isCorrectVerifyCode {
+		s.recordFailure(ipThrottleKey(r), ipLockoutThreshold, ipLockoutDuration)
+		attempts, err := s.db.IncrementVerifyAttempts(user.ID)
+		if err == nil && attempts >= maxVerifyAttempts {
+			s.db.InvalidateVerifyCode(user.ID)
+			w.WriteHeader(http.StatusBadRequest)
+			res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "too many invalid codes, sign in to receive a new one"}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
 		w.WriteHeader(http.StatusBadRequest)
 		res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "invalid verify code"}
 		json.NewEncoder(w).Encode(res)
 		return
 	}
-	userId, err := s.db.VerifyUser(username)
+	if user.PendingEmail != "" {
+		if s.db.CheckExistingUser("", user.PendingEmail) {
+			w.WriteHeader(http.StatusConflict)
+			res := types.Response{StatusCode: http.StatusConflict, Success: false, Message: "email already taken"}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		err = s.db.ConfirmPendingEmail(user.ID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error changing email", Error: err.Error()}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		s.audit(r, user.ID, models.AuditEmailChanged, map[string]string{"from": user.Email, "to": user.PendingEmail})
+		res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "email changed successfully", Data: map[string]interface{}{"email": user.PendingEmail}}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	userId, err := s.db.VerifyUser(user.Username)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "error verifying user", Error: err.Error()}
 		json.NewEncoder(w).Encode(res)
 		return
 	}
+	s.audit(r, user.ID, models.AuditVerified, nil)
 
 	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "user verified successfully", Data: map[string]interface{}{"userId": userId}}
 	json.NewEncoder(w).Encode(res)
@@ -292,6 +450,7 @@ func (s *Server) AcceptMessages(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(res)
 		return
 	}
+	s.audit(r, userIdObjectId, models.AuditAcceptMessages, map[string]string{"accepting": strconv.FormatBool(isAcceptingMessages)})
 	w.WriteHeader(http.StatusOK)
 	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "accept message status updated successfully"}
 	json.NewEncoder(w).Encode(res)
@@ -326,6 +485,24 @@ func (s *Server) SendMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !user.DeletionScheduledAt.IsZero() {
+		w.WriteHeader(http.StatusNotFound)
+		res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "user not found"}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if suspended, err := s.db.IsUserSuspended(user.ID); err != nil || suspended {
+		status, message := http.StatusNotFound, "user not found"
+		if err != nil {
+			status, message = http.StatusInternalServerError, "internal server error"
+		}
+		w.WriteHeader(status)
+		res := types.Response{StatusCode: status, Success: false, Message: message}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
 	if !user.IsAcceptingMessages {
 		w.WriteHeader(http.StatusForbidden)
 		res := types.Response{StatusCode: http.StatusForbidden, Success: false, Message: "user is not accepting messages"}
@@ -333,27 +510,132 @@ func (s *Server) SendMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	message := models.Message{
-		ID:        primitive.NewObjectID(),
-		Content:   sendMessageData.Content,
-		CreatedAt: time.Time{},
+	var prompt *models.Prompt
+	if sendMessageData.Prompt != "" {
+		prompt = user.FindPrompt(sendMessageData.Prompt)
+		if prompt == nil {
+			w.WriteHeader(http.StatusNotFound)
+			res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "prompt not found"}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		if !prompt.AcceptsMessages() {
+			w.WriteHeader(http.StatusForbidden)
+			res := types.Response{StatusCode: http.StatusForbidden, Success: false, Message: "prompt is closed"}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
 	}
 
-	err = s.db.AddMessage(sendMessageData.Identifier, message)
-	if err != nil {
-		if err == fmt.Errorf("user not found") {
-			w.WriteHeader(http.StatusNotFound)
-			res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "user not found", Error: err.Error()}
+	// the schedule and prompt slots are taken up front so two senders cannot
+	// both get the last one, they are given back if the message is not stored
+	var scheduleSlot, promptSlot, stored bool
+	defer func() {
+		if !stored && scheduleSlot {
+			if err := s.db.ReleaseScheduledMessage(user.ID); err != nil {
+				log.Printf("error releasing schedule slot of %s: %v", user.ID.Hex(), err)
+			}
+		}
+		if !stored && promptSlot {
+			if err := s.db.ReleasePromptMessage(user.ID, prompt.ID); err != nil {
+				log.Printf("error releasing slot of prompt %s: %v", prompt.ID.Hex(), err)
+			}
+		}
+	}()
+
+	if user.Schedule != nil {
+		open, opensAt := user.Schedule.IsOpen(time.Now())
+		if open {
+			open, err = s.db.CountScheduledMessage(user.ID, user.Schedule.MaxMessages)
+			scheduleSlot = open
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+				json.NewEncoder(w).Encode(res)
+				return
+			}
+		}
+		if !open {
+			w.WriteHeader(http.StatusForbidden)
+			res := types.Response{StatusCode: http.StatusForbidden, Success: false, Message: "user is not accepting messages right now", Data: map[string]interface{}{"opens_at": opensAt}}
 			json.NewEncoder(w).Encode(res)
 			return
 		}
+	}
+
+	if prompt != nil {
+		open, err := s.db.CountPromptMessage(user.ID, *prompt)
+		promptSlot = open
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		if !open {
+			w.WriteHeader(http.StatusForbidden)
+			res := types.Response{StatusCode: http.StatusForbidden, Success: false, Message: "prompt is closed"}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+	}
+
+	// blocked and suspended senders go through every check above and get the
+	// usual answer and a working token, their message just never arrives
+	fingerprints := utils.SenderFingerprints(r, time.Now())
+	blocked, err := s.db.IsSenderBlocked(user.ID, fingerprints)
+	if err == nil && !blocked {
+		blocked, err = s.db.IsSenderSuspended(fingerprints)
+	}
+	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
 		json.NewEncoder(w).Encode(res)
 		return
 	}
+
+	message := models.Message{
+		ID:          primitive.NewObjectID(),
+		Content:     sendMessageData.Content,
+		Source:      shareTag(sendMessageData.Source),
+		Medium:      shareTag(sendMessageData.Medium),
+		Fingerprint: fingerprints[0],
+		State:       models.MessageUnread,
+		CreatedAt:   time.Now(),
+	}
+	message.ExpiresAt = user.Retention.ExpiresAt(message.CreatedAt)
+	if prompt != nil {
+		message.PromptID = prompt.ID
+	}
+
+	// only the sender ever sees the token, it lets them read the reply later
+	senderToken, err := utils.RandomToken(32)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	message.SenderTokenHash = utils.HashToken(senderToken)
+
+	if blocked {
+		message.Fingerprint = ""
+		err = s.db.AddDroppedMessage(user.ID, message)
+	} else {
+		err = s.db.AddMessage(user.ID, message)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		res := types.Response{StatusCode: http.StatusInternalServerError, Success: false, Message: "internal server error", Error: err.Error()}
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	stored = true
+
 	w.WriteHeader(http.StatusCreated)
-	res := types.Response{StatusCode: http.StatusCreated, Success: true, Message: "message sent successfully"}
+	res := types.Response{StatusCode: http.StatusCreated, Success: true, Message: "message sent successfully", Data: map[string]interface{}{
+		"sender_token": senderToken,
+	}}
 	json.NewEncoder(w).Encode(res)
 }
 
@@ -367,7 +649,22 @@ func (s *Server) GetMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	messages, err := s.db.GetMessages(userIdObjectId)
+	var filter models.MessageFilter
+	if slug := r.URL.Query().Get("prompt"); slug != "" {
+		var prompt *models.Prompt
+		if user := s.db.GetUserByID(userIdObjectId); user != nil {
+			prompt = user.FindPrompt(slug)
+		}
+		if prompt == nil {
+			w.WriteHeader(http.StatusNotFound)
+			res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "prompt not found"}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		filter.PromptID = prompt.ID
+	}
+
+	messages, err := s.db.GetMessages(userIdObjectId, filter)
 	if err == nil && messages == nil {
 		w.WriteHeader(http.StatusNotFound)
 		res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "no messages currently"}
@@ -406,9 +703,9 @@ func (s *Server) DeleteMessage(w http.ResponseWriter, r *http.Request) {
 
 	err = s.db.DeleteMessage(userId, messagesId)
 	if err != nil {
-		if err == fmt.Errorf("user not found") {
+		if errors.Is(err, database.ErrMessageNotFound) {
 			w.WriteHeader(http.StatusNotFound)
-			res := types.Response{StatusCode: http.StatusBadRequest, Success: false, Message: "user not found", Error: err.Error()}
+			res := types.Response{StatusCode: http.StatusNotFound, Success: false, Message: "message not found", Error: err.Error()}
 			json.NewEncoder(w).Encode(res)
 			return
 		}
@@ -417,7 +714,8 @@ func (s *Server) DeleteMessage(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(res)
 		return
 	}
+	s.audit(r, userId, models.AuditMessageDeleted, map[string]string{"message_id": messagesId.Hex()})
 	w.WriteHeader(http.StatusOK)
-	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "message deleted successfully"}
+	res := types.Response{StatusCode: http.StatusOK, Success: true, Message: "message moved to trash"}
 	json.NewEncoder(w).Encode(res)
 }
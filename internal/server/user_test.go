@@ -0,0 +1,65 @@
+package server
+
+import (
+	"encoding/json"
+	"silent-notes/internal/models"
+	"silent-notes/internal/types"
+	"strings"
+	"testing"
+)
+
+func TestSignUpIgnoresServerOwnedFields(t *testing.T) {
+	body := `{
+		"username": "mallory", "email": "mallory@example.com", "password": "correct horse",
+		"role": "admin", "is_verified": true, "mfa_enabled": true,
+		"prompts": [{"slug": "free"}], "schedule": {"max_messages": 1},
+		"profile": {"avatar_keys": ["avatars/someone-else/1/256.png"]},
+		"retention": {"after_days": 7}, "pending_email": "x@example.com",
+		"deletion_scheduled_at": "2030-01-01T00:00:00Z",
+		"messages": [{"content": "planted"}]
+	}`
+
+	var data types.SignUpType
+	if err := json.NewDecoder(strings.NewReader(body)).Decode(&data); err != nil {
+		t.Fatalf("decoding sign-up: %v", err)
+	}
+	user, err := newSignUpUser(data)
+	if err != nil {
+		t.Fatalf("newSignUpUser: %v", err)
+	}
+
+	if user.EffectiveRole() != models.RoleUser || user.Role != "" {
+		t.Fatalf("role = %q, want the default role", user.Role)
+	}
+	if user.IsVerified || user.MFAEnabled || user.Prompts != nil || user.Schedule != nil || user.Messages != nil ||
+		len(user.Profile.AvatarKeys) > 0 || user.Retention.AfterDays != 0 || user.PendingEmail != "" || !user.DeletionScheduledAt.IsZero() {
+		t.Fatalf("sign-up kept server-owned fields: %+v", user)
+	}
+	if user.Username != "mallory" || user.Email != "mallory@example.com" || user.Password == "correct horse" || !user.IsAcceptingMessages {
+		t.Fatalf("sign-up form not applied: %+v", user)
+	}
+}
+
+func TestUserRoleIsNotDecodedFromJSON(t *testing.T) {
+	var user models.UserModel
+	if err := json.Unmarshal([]byte(`{"role": "admin"}`), &user); err != nil {
+		t.Fatalf("decoding user: %v", err)
+	}
+	if user.Role != "" {
+		t.Fatalf("role = %q, want it ignored", user.Role)
+	}
+}
+
+func TestUsernamePattern(t *testing.T) {
+	for _, username := range []string{"mallory", "mallory_99", "abc"} {
+		if !usernamePattern.MatchString(username) {
+			t.Errorf("%q rejected", username)
+		}
+	}
+	// the second one starts with a Cyrillic а
+	for _, username := range []string{"Mallory", "аdmin", "mal lory", "mal\tlory", "ab", "mallory-99", strings.Repeat("a", 31)} {
+		if usernamePattern.MatchString(username) {
+			t.Errorf("%q accepted", username)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps uploaded media. Keys are slash separated paths such as
+// "avatars/<user id>/<version>/256.png".
+type Store interface {
+	Put(ctx context.Context, key, contentType string, data []byte) error
+	// Get returns the blob and its content type, ErrNotFound if it does not exist.
+	Get(ctx context.Context, key string) (io.ReadCloser, string, error)
+	Delete(ctx context.Context, key string) error
+	// DeletePrefix removes every blob whose key starts with prefix, which has
+	// to end in a slash.
+	DeletePrefix(ctx context.Context, prefix string) error
+}
+
+// New picks the backend from STORAGE_DRIVER, "local" (the default) or "s3":
+//
+//	STORAGE_DIR=./uploads                       (local)
+//	S3_ENDPOINT=http://localhost:9000           (s3, any S3 compatible service)
+//	S3_REGION=us-east-1
+//	S3_BUCKET=silent-notes
+//	S3_ACCESS_KEY=...
+//	S3_SECRET_KEY=...
+func New() (Store, error) {
+	switch strings.ToLower(os.Getenv("STORAGE_DRIVER")) {
+	case "", "local":
+		dir := os.Getenv("STORAGE_DIR")
+		if dir == "" {
+			dir = filepath.Join(".", "uploads")
+		}
+		return NewLocal(dir)
+	case "s3":
+		return NewS3(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+		})
+	default:
+		return nil, fmt.Errorf("unsupported STORAGE_DRIVER %q, use local or s3", os.Getenv("STORAGE_DRIVER"))
+	}
+}
+
+func validPrefix(prefix string) error {
+	if !strings.HasSuffix(prefix, "/") {
+		return fmt.Errorf("blob prefix %q must end in a slash", prefix)
+	}
+	return validKey(strings.TrimSuffix(prefix, "/"))
+}
+
+// validKey rejects keys that could escape the store, every backend checks it.
+func validKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid blob key %q", key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package types
+
+import "time"
+
+// SignUpType is all a new account may choose, everything else on the user is
+// set by the server.
+type SignUpType struct {
+	Username string `json:"username" validate:"required,min=3,max=30"`
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+}
+
+type ChangePasswordType struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password" validate:"required,min=8,max=72"`
+}
+
+type ChangeEmailType struct {
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type ChangeUsernameType struct {
+	Username string `json:"username" validate:"required,min=3,max=30"`
+}
+
+type DeleteAccountType struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// RetentionType sets the inbox retention policy. AfterRead only covers
+// messages read through the mark_read bulk action.
+type RetentionType struct {
+	AfterDays int  `json:"after_days" validate:"oneof=0 7 30 90"`
+	AfterRead bool `json:"after_read"`
+	// Confirm accepts that messages already past the new limit are deleted
+	Confirm bool `json:"confirm"`
+}
+
+type AcceptScheduleType struct {
+	Timezone    string               `json:"timezone" validate:"required,timezone"`
+	Windows     []ScheduleWindowType `json:"windows" validate:"max=21,dive"`
+	MaxMessages int                  `json:"max_messages" validate:"min=0,max=100000"`
+	CloseAt     *time.Time           `json:"close_at"`
+}
+
+type ScheduleWindowType struct {
+	Days  []int  `json:"days" validate:"required,min=1,max=7,dive,min=0,max=6"`
+	Start string `json:"start" validate:"required,datetime=15:04"`
+	End   string `json:"end" validate:"required,datetime=15:04,nefield=Start"`
+}
+
+type ProfileType struct {
+	DisplayName string `json:"display_name" validate:"max=50"`
+	Bio         string `json:"bio" validate:"max=300"`
+	PromptText  string `json:"prompt_text" validate:"max=150"`
+}
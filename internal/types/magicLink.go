@@ -0,0 +1,9 @@
+package types
+
+type MagicLinkRequestType struct {
+	Identifier string `json:"identifier" validate:"required"`
+}
+
+type MagicLinkCallbackType struct {
+	Token string `json:"token" validate:"required"`
+}
@@ -0,0 +1,21 @@
+package types
+
+import "time"
+
+type BulkMessageType struct {
+	Action string                 `json:"action" validate:"required,oneof=delete archive mark_read"`
+	IDs    []string               `json:"ids" validate:"max=1000"`
+	Filter *BulkMessageFilterType `json:"filter"`
+}
+
+// BulkMessageFilterType selects messages instead of listing ids, at least one
+// field has to be set so a stray request cannot empty the inbox.
+type BulkMessageFilterType struct {
+	OlderThan time.Time `json:"older_than"`
+	Contains  string    `json:"contains" validate:"max=300"`
+	Unread    bool      `json:"unread"`
+}
+
+type MessageReplyType struct {
+	Content string `json:"content" validate:"required,min=1,max=300"`
+}
@@ -0,0 +1,20 @@
+package types
+
+type MFACodeType struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+type MFADisableType struct {
+	Password string `json:"password" validate:"required"`
+	Code     string `json:"code" validate:"required_without=RecoveryCode"`
+	// a recovery code also works for users who lost their authenticator
+	RecoveryCode string `json:"recovery_code" validate:"required_without=Code"`
+}
+
+type MFASignInType struct {
+	// MFAToken may be left out after an OIDC login, the challenge is then
+	// in the mfa_token cookie
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code" validate:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recovery_code" validate:"required_without=Code"`
+}
@@ -0,0 +1,29 @@
+package types
+
+type ReportType struct {
+	Category string `json:"category" validate:"required,oneof=spam harassment threat self_harm illegal other"`
+	Details  string `json:"details" validate:"max=500"`
+}
+
+type ModerationNoteType struct {
+	Note string `json:"note" validate:"max=500"`
+}
+
+// SuspensionType suspends the sender of a reported message (kind "sender",
+// ReportID set) or an account (kind "user", UserID set). Days 0 is until lifted.
+type SuspensionType struct {
+	Kind     string `json:"kind" validate:"required,oneof=sender user"`
+	ReportID string `json:"report_id" validate:"required_if=Kind sender,omitempty,len=24,hexadecimal"`
+	UserID   string `json:"user_id" validate:"required_if=Kind user,omitempty,len=24,hexadecimal"`
+	Reason   string `json:"reason" validate:"required,max=500"`
+	Days     int    `json:"days" validate:"min=0,max=3650"`
+}
+
+type RoleType struct {
+	Role string `json:"role" validate:"required,oneof=user moderator admin"`
+}
+
+type UserSuspensionType struct {
+	Reason string `json:"reason" validate:"required,max=500"`
+	Days   int    `json:"days" validate:"min=0,max=3650"`
+}
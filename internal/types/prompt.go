@@ -0,0 +1,9 @@
+package types
+
+type PromptType struct {
+	Slug        string `json:"slug" validate:"required,min=2,max=40"`
+	Title       string `json:"title" validate:"required,max=100"`
+	Description string `json:"description" validate:"max=300"`
+	IsOpen      *bool  `json:"is_open"`
+	MaxMessages int    `json:"max_messages" validate:"min=0,max=100000"`
+}
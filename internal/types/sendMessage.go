@@ -3,4 +3,19 @@ package types
 type SendMessageType struct {
 	Identifier string `json:"identifier" validate:"required,min=3,max=30"`
 	Content    string `json:"content" validate:"required,min=10,max=300"`
+	Prompt     string `json:"prompt" validate:"omitempty,max=40"`
+	// utm_source and utm_medium of the share link, if the sender came through one
+	Source string `json:"source" validate:"omitempty,max=32"`
+	Medium string `json:"medium" validate:"omitempty,max=32"`
+}
+
+// SenderThreadType carries the token SendMessage handed the sender, in the
+// body so it stays out of URLs and access logs.
+type SenderThreadType struct {
+	Token string `json:"token" validate:"required,max=100"`
+}
+
+type FollowUpType struct {
+	Token   string `json:"token" validate:"required,max=100"`
+	Content string `json:"content" validate:"required,min=10,max=300"`
 }
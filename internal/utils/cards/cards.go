@@ -0,0 +1,244 @@
+package cards
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Theme colours a card. Background runs from Top to Bottom.
+type Theme struct {
+	Top    color.RGBA
+	Bottom color.RGBA
+	Panel  color.RGBA
+	Text   color.RGBA
+	Accent color.RGBA
+}
+
+var Themes = map[string]Theme{
+	"midnight": {
+		Top: rgb(0x1e1b4b), Bottom: rgb(0x0f172a), Panel: rgb(0xffffff), Text: rgb(0x111827), Accent: rgb(0x6366f1),
+	},
+	"sunset": {
+		Top: rgb(0xf97316), Bottom: rgb(0xdb2777), Panel: rgb(0xfff7ed), Text: rgb(0x1f2937), Accent: rgb(0xea580c),
+	},
+	"paper": {
+		Top: rgb(0xfafaf9), Bottom: rgb(0xe7e5e4), Panel: rgb(0xffffff), Text: rgb(0x292524), Accent: rgb(0x57534e),
+	},
+}
+
+const DefaultTheme = "midnight"
+
+// Sizes in pixels for each aspect, story fits a phone screen.
+var Aspects = map[string]image.Point{
+	"story":  {X: 1080, Y: 1920},
+	"square": {X: 1080, Y: 1080},
+}
+
+type Card struct {
+	Question string
+	Answer   string
+	// Footer is printed small at the bottom, usually the profile link
+	Footer string
+	Theme  string
+	Aspect string
+}
+
+var (
+	fontsOnce             sync.Once
+	regularFont, boldFont *opentype.Font
+	fontsErr              error
+)
+
+func loadFonts() {
+	regularFont, fontsErr = opentype.Parse(goregular.TTF)
+	if fontsErr == nil {
+		boldFont, fontsErr = opentype.Parse(gobold.TTF)
+	}
+}
+
+// Render draws the card as a PNG.
+func Render(card Card) ([]byte, error) {
+	fontsOnce.Do(loadFonts)
+	if fontsErr != nil {
+		return nil, fontsErr
+	}
+	theme, ok := Themes[card.Theme]
+	if !ok {
+		return nil, fmt.Errorf("unknown theme %q", card.Theme)
+	}
+	size, ok := Aspects[card.Aspect]
+	if !ok {
+		return nil, fmt.Errorf("unknown aspect %q", card.Aspect)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+	for y := 0; y < size.Y; y++ {
+		c := mix(theme.Top, theme.Bottom, float64(y)/float64(size.Y-1))
+		draw.Draw(img, image.Rect(0, y, size.X, y+1), image.NewUniform(c), image.Point{}, draw.Src)
+	}
+
+	margin := size.X / 12
+	padding := size.X / 16
+	panel := image.Rect(margin, size.Y/8, size.X-margin, size.Y-size.Y/8)
+	fillRounded(img, panel, size.X/27, theme.Panel)
+
+	textWidth := panel.Dx() - 2*padding
+	label, err := face(boldFont, 34)
+	if err != nil {
+		return nil, err
+	}
+	defer label.Close()
+
+	// the question and answer share the panel, start big and shrink until both fit
+	available := panel.Dy() - 2*padding - 2*lineHeight(label)
+	var question, answer font.Face
+	var questionLines, answerLines []string
+	for points := 72.0; ; points -= 4 {
+		if question != nil {
+			question.Close()
+			answer.Close()
+		}
+		if question, err = face(boldFont, points); err != nil {
+			return nil, err
+		}
+		if answer, err = face(regularFont, points*0.8); err != nil {
+			return nil, err
+		}
+		questionLines = wrap(question, card.Question, textWidth)
+		answerLines = wrap(answer, card.Answer, textWidth)
+		height := len(questionLines) * lineHeight(question)
+		if len(answerLines) > 0 {
+			height += padding + len(answerLines)*lineHeight(answer)
+		}
+		if height <= available || points <= 24 {
+			break
+		}
+	}
+	defer question.Close()
+	defer answer.Close()
+
+	x, y := panel.Min.X+padding, panel.Min.Y+padding
+	y = drawLines(img, label, []string{"ANONYMOUS MESSAGE"}, x, y, theme.Accent)
+	y = drawLines(img, question, questionLines, x, y, theme.Text)
+	if len(answerLines) > 0 {
+		y += padding / 2
+		draw.Draw(img, image.Rect(x, y, x+textWidth/6, y+6), image.NewUniform(theme.Accent), image.Point{}, draw.Src)
+		y += padding / 2
+		drawLines(img, answer, answerLines, x, y, theme.Text)
+	}
+
+	if card.Footer != "" {
+		footer, err := face(regularFont, 30)
+		if err != nil {
+			return nil, err
+		}
+		defer footer.Close()
+		footerColor := theme.Panel
+		if card.Theme == "paper" {
+			footerColor = theme.Text
+		}
+		drawLines(img, footer, []string{card.Footer}, margin, panel.Max.Y+padding/2, footerColor)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func face(f *opentype.Font, points float64) (font.Face, error) {
+	return opentype.NewFace(f, &opentype.FaceOptions{Size: points, DPI: 72, Hinting: font.HintingFull})
+}
+
+func lineHeight(f font.Face) int {
+	return f.Metrics().Height.Ceil() * 5 / 4
+}
+
+// wrap breaks text into lines no wider than width, keeping the author's line breaks.
+func wrap(f font.Face, text string, width int) []string {
+	var lines []string
+	limit := fixed.I(width)
+	for _, paragraph := range strings.Split(strings.TrimSpace(text), "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			// words longer than a line are split wherever they overflow
+			for font.MeasureString(f, word) > limit {
+				cut := len([]rune(word))
+				for cut > 1 && font.MeasureString(f, string([]rune(word)[:cut])) > limit {
+					cut--
+				}
+				if line != "" {
+					lines = append(lines, line)
+					line = ""
+				}
+				lines = append(lines, string([]rune(word)[:cut]))
+				word = string([]rune(word)[cut:])
+			}
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if font.MeasureString(f, candidate) <= limit {
+				line = candidate
+				continue
+			}
+			lines = append(lines, line)
+			line = word
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// drawLines writes lines top-down from y and returns the y below the last one.
+func drawLines(img draw.Image, f font.Face, lines []string, x, y int, c color.RGBA) int {
+	drawer := font.Drawer{Dst: img, Src: image.NewUniform(c), Face: f}
+	for _, line := range lines {
+		y += lineHeight(f)
+		drawer.Dot = fixed.P(x, y-f.Metrics().Descent.Ceil())
+		drawer.DrawString(line)
+	}
+	return y
+}
+
+func fillRounded(img draw.Image, r image.Rectangle, radius int, c color.RGBA) {
+	src := image.NewUniform(c)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		inset := 0
+		if dy := min(y-r.Min.Y, r.Max.Y-1-y); dy < radius {
+			// distance from the corner circle's centre decides how far the row is inset
+			d := radius - dy
+			for inset = 0; inset < radius; inset++ {
+				dx := radius - inset
+				if dx*dx+d*d <= radius*radius {
+					break
+				}
+			}
+		}
+		draw.Draw(img, image.Rect(r.Min.X+inset, y, r.Max.X-inset, y+1), src, image.Point{}, draw.Src)
+	}
+}
+
+func mix(a, b color.RGBA, t float64) color.RGBA {
+	blend := func(x, y uint8) uint8 { return uint8(float64(x) + (float64(y)-float64(x))*t) }
+	return color.RGBA{blend(a.R, b.R), blend(a.G, b.G), blend(a.B, b.B), 255}
+}
+
+func rgb(hex uint32) color.RGBA {
+	return color.RGBA{uint8(hex >> 16), uint8(hex >> 8), uint8(hex), 255}
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DurationFromEnv reads a Go duration such as "72h" from key, falling back to
+// def when the variable is unset or invalid.
+func DurationFromEnv(key string, def time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return def
+	}
+	return value
+}
+
+// AccountDeletionGrace is how long a deletion request can still be cancelled.
+func AccountDeletionGrace() time.Duration {
+	return DurationFromEnv("ACCOUNT_DELETION_GRACE", 14*24*time.Hour)
+}
+
+// ExportDir is where finished data export archives are written.
+func ExportDir() string {
+	if dir := os.Getenv("EXPORT_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "silent-notes-exports")
+}
+
+// ExportLinkTTL is how long a finished data export can be downloaded.
+func ExportLinkTTL() time.Duration {
+	return DurationFromEnv("EXPORT_LINK_TTL", 48*time.Hour)
+}
+
+// TrashRetention is how long deleted messages can be restored before they are
+// purged for good.
+func TrashRetention() time.Duration {
+	return DurationFromEnv("TRASH_RETENTION", 30*24*time.Hour)
+}
+
+// ReadMessageTTL is how long a read message survives in an inbox whose
+// retention policy deletes messages after they are read.
+func ReadMessageTTL() time.Duration {
+	return DurationFromEnv("READ_MESSAGE_TTL", 24*time.Hour)
+}
+
+// FingerprintRotation is how often the salt behind sender fingerprints
+// changes. A block keeps working for one to two rotations.
+func FingerprintRotation() time.Duration {
+	return DurationFromEnv("FINGERPRINT_ROTATION", 30*24*time.Hour)
+}
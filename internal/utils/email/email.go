@@ -2,9 +2,11 @@ package email
 
 import (
 	"bytes"
+	"fmt"
 	"html/template"
 	"log"
 	"os"
+	"time"
 
 	"gopkg.in/gomail.v2"
 )
@@ -20,14 +22,98 @@ type EmailStrut struct {
 	Code int
 }
 
+// NoticeStrut fills notice.html, the shared layout for every non-verification email.
+type NoticeStrut struct {
+	Name     string
+	Heading  string
+	Lines    []string
+	Link     string
+	LinkText string
+}
+
 func SendVerificationEmail(username, email string, otp int) error {
+	return send(email, subject, "internal/utils/email/email.html", EmailStrut{Name: username, Code: otp})
+}
+
+func SendLockoutEmail(username, email string, lockedUntil time.Time) error {
+	return send(email, "AMA | Sign-in locked", "internal/utils/email/notice.html", NoticeStrut{
+		Name:    username,
+		Heading: "Too many sign-in attempts",
+		Lines: []string{
+			"We locked sign-in to your account after several failed attempts.",
+			fmt.Sprintf("You can try again after %s.", lockedUntil.UTC().Format("Jan 2, 2006 15:04 MST")),
+			"If this wasn't you, someone may be guessing your password. Consider changing it and enabling two-factor authentication.",
+		},
+	})
+}
+
+func SendMagicLinkEmail(username, email, link string) error {
+	return send(email, "AMA | Your sign-in link", "internal/utils/email/notice.html", NoticeStrut{
+		Name:    username,
+		Heading: "Sign in to AMA",
+		Lines: []string{
+			"Use the button below to sign in. The link works once and expires in 15 minutes.",
+		},
+		Link:     link,
+		LinkText: "Sign in",
+	})
+}
+
+func SendEmailChangeRequestedEmail(username, email, newEmail string) error {
+	return send(email, "AMA | Email change requested", "internal/utils/email/notice.html", NoticeStrut{
+		Name:    username,
+		Heading: "Your email address is being changed",
+		Lines: []string{
+			fmt.Sprintf("Someone signed in to your account asked to move it to %s.", newEmail),
+			"The change only happens once the code we sent there is entered.",
+			"If this wasn't you, change your password and sign out of your other sessions right away.",
+		},
+	})
+}
+
+func SendDeletionScheduledEmail(username, email string, deleteAt time.Time) error {
+	return send(email, "AMA | Account deletion scheduled", "internal/utils/email/notice.html", NoticeStrut{
+		Name:    username,
+		Heading: "Your account is scheduled for deletion",
+		Lines: []string{
+			fmt.Sprintf("Your account and all of its messages will be permanently deleted on %s.", deleteAt.UTC().Format("Jan 2, 2006 15:04 MST")),
+			"Changed your mind? Just sign in before then and the deletion is cancelled.",
+		},
+	})
+}
+
+func SendAccountDeletedEmail(username, email string) error {
+	return send(email, "AMA | Account deleted", "internal/utils/email/notice.html", NoticeStrut{
+		Name:    username,
+		Heading: "Your account has been deleted",
+		Lines: []string{
+			"As requested, your account, messages and all related data have been permanently removed.",
+			"Thanks for using AMA.",
+		},
+	})
+}
+
+func SendExportReadyEmail(username, email, link string, expiresAt time.Time) error {
+	return send(email, "AMA | Your data export is ready", "internal/utils/email/notice.html", NoticeStrut{
+		Name:    username,
+		Heading: "Your data export is ready",
+		Lines: []string{
+			"The copy of your data you asked for is ready to download.",
+			fmt.Sprintf("The link expires on %s, after that you can request a new export.", expiresAt.UTC().Format("Jan 2, 2006 15:04 MST")),
+		},
+		Link:     link,
+		LinkText: "Download your data",
+	})
+}
+
+func send(email, subject, templateFile string, data interface{}) error {
 
 	var body bytes.Buffer
-	t, err := template.ParseFiles("internal/utils/email/email.html")
+	t, err := template.ParseFiles(templateFile)
 	if err != nil {
 		return err
 	}
-	if err := t.Execute(&body, EmailStrut{Name: username, Code: otp}); err != nil {
+	if err := t.Execute(&body, data); err != nil {
 		return err
 	}
 
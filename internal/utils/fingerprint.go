@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	fingerprintSecretOnce sync.Once
+	fingerprintSecret     []byte
+)
+
+// loadFingerprintSecret reads FINGERPRINT_SECRET. Without it a random secret
+// is used, which works but forgets every block on restart.
+func loadFingerprintSecret() {
+	if secret := os.Getenv("FINGERPRINT_SECRET"); secret != "" {
+		fingerprintSecret = []byte(secret)
+		return
+	}
+	log.Println("FINGERPRINT_SECRET is not set, sender blocks will not survive a restart")
+	fingerprintSecret = make([]byte, 32)
+	if _, err := rand.Read(fingerprintSecret); err != nil {
+		log.Fatalf("error generating fingerprint secret: %v", err)
+	}
+}
+
+// FingerprintEpoch numbers the salt rotation period now falls in.
+func FingerprintEpoch(now time.Time) int64 {
+	return now.Unix() / int64(FingerprintRotation().Seconds())
+}
+
+// FingerprintExpiry is when fingerprints taken during epoch stop matching new
+// senders, the end of the epoch after it.
+func FingerprintExpiry(epoch int64) time.Time {
+	return time.Unix((epoch+2)*int64(FingerprintRotation().Seconds()), 0)
+}
+
+// SenderFingerprint identifies a sender by the network they write from (the
+// IPv4 /24 or IPv6 /48) and their user agent, keyed with a salt that changes
+// every epoch so it cannot be reversed or tracked for long. The result is
+// "<epoch>.<hex>".
+func SenderFingerprint(r *http.Request, epoch int64) string {
+	fingerprintSecretOnce.Do(loadFingerprintSecret)
+
+	salt := hmac.New(sha256.New, fingerprintSecret)
+	salt.Write([]byte("sender-fingerprint:" + strconv.FormatInt(epoch, 10)))
+
+	agent := sha256.Sum256([]byte(r.UserAgent()))
+	mac := hmac.New(sha256.New, salt.Sum(nil))
+	mac.Write([]byte(networkPrefix(ClientIP(r)) + "\n" + hex.EncodeToString(agent[:])))
+	return strconv.FormatInt(epoch, 10) + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SenderFingerprints returns the request's fingerprint under the current and
+// the previous salt, blocks from either one apply.
+func SenderFingerprints(r *http.Request, now time.Time) []string {
+	epoch := FingerprintEpoch(now)
+	return []string{SenderFingerprint(r, epoch), SenderFingerprint(r, epoch-1)}
+}
+
+// ParseFingerprintEpoch reads the epoch back out of a fingerprint.
+func ParseFingerprintEpoch(fingerprint string) (int64, bool) {
+	epoch, _, found := strings.Cut(fingerprint, ".")
+	if !found {
+		return 0, false
+	}
+	value, err := strconv.ParseInt(epoch, 10, 64)
+	return value, err == nil
+}
+
+func networkPrefix(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String() + "/48"
+}
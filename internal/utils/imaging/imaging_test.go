@@ -0,0 +1,142 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"strings"
+	"testing"
+)
+
+var (
+	red  = color.NRGBA{R: 255, A: 255}
+	blue = color.NRGBA{B: 255, A: 255}
+)
+
+// halves is w x h pixels, red on the left half and blue on the right.
+func halves(w, h int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x < w/2 {
+				img.Set(x, y, red)
+			} else {
+				img.Set(x, y, blue)
+			}
+		}
+	}
+	return img
+}
+
+// jpegWithOrientation encodes img and inserts an EXIF APP1 segment holding
+// only the orientation tag right after the start of image marker.
+func jpegWithOrientation(t *testing.T, img image.Image, orientation uint16, order binary.ByteOrder) []byte {
+	t.Helper()
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatalf("encoding jpeg: %v", err)
+	}
+
+	tiff := make([]byte, 8+2+12+4)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], 0x0112) // orientation
+	order.PutUint16(tiff[12:], 3)      // SHORT
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], orientation)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+
+	data := encoded.Bytes()
+	return append(append(append([]byte{}, data[:2]...), app1...), data[2:]...)
+}
+
+func near(c color.Color, want color.NRGBA) bool {
+	r, g, b, _ := c.RGBA()
+	diff := func(got uint32, want uint8) bool {
+		d := int(got>>8) - int(want)
+		return d > -48 && d < 48
+	}
+	return diff(r, want.R) && diff(g, want.G) && diff(b, want.B)
+}
+
+func TestDecodeAppliesOrientation(t *testing.T) {
+	// each case says which colour ends up at the top left and bottom right
+	tests := []struct {
+		orientation       uint16
+		width, height     int
+		topLeft, botRight color.NRGBA
+	}{
+		{1, 32, 16, red, blue},
+		{3, 32, 16, blue, red},
+		{6, 16, 32, red, blue},
+		{8, 16, 32, blue, red},
+	}
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for _, tt := range tests {
+			img, err := Decode(jpegWithOrientation(t, halves(32, 16), tt.orientation, order))
+			if err != nil {
+				t.Fatalf("Decode orientation %d: %v", tt.orientation, err)
+			}
+			bounds := img.Bounds()
+			if bounds.Dx() != tt.width || bounds.Dy() != tt.height {
+				t.Fatalf("orientation %d (%s) decoded to %dx%d, want %dx%d", tt.orientation, order, bounds.Dx(), bounds.Dy(), tt.width, tt.height)
+			}
+			if !near(img.At(bounds.Min.X+2, bounds.Min.Y+2), tt.topLeft) || !near(img.At(bounds.Max.X-3, bounds.Max.Y-3), tt.botRight) {
+				t.Fatalf("orientation %d (%s) is not upright", tt.orientation, order)
+			}
+		}
+	}
+}
+
+func TestJPEGOrientationIgnoresBrokenExif(t *testing.T) {
+	data := jpegWithOrientation(t, halves(8, 8), 6, binary.BigEndian)
+	// point the first IFD past the end of the segment
+	binary.BigEndian.PutUint32(data[2+4+6+4:], 0xFFFF)
+
+	if got := jpegOrientation(data); got != 1 {
+		t.Fatalf("jpegOrientation = %d, want 1 for a broken IFD offset", got)
+	}
+	if got := jpegOrientation([]byte("not a jpeg")); got != 1 {
+		t.Fatalf("jpegOrientation = %d, want 1 for other data", got)
+	}
+}
+
+func TestDecodeRejectsTooManyPixels(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := gif.Encode(&encoded, image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.Black}), nil); err != nil {
+		t.Fatalf("encoding gif: %v", err)
+	}
+	// the logical screen claims 10000x10000 while the file stays tiny
+	data := encoded.Bytes()
+	binary.LittleEndian.PutUint16(data[6:], 10000)
+	binary.LittleEndian.PutUint16(data[8:], 10000)
+
+	if _, err := Decode(data); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("Decode returned %v, want the pixel cap to refuse it", err)
+	}
+}
+
+func TestSniff(t *testing.T) {
+	var encoded bytes.Buffer
+	jpeg.Encode(&encoded, halves(8, 8), nil)
+	if contentType, err := Sniff(encoded.Bytes()); err != nil || contentType != "image/jpeg" {
+		t.Fatalf("Sniff jpeg = %q, %v", contentType, err)
+	}
+	if _, err := Sniff([]byte("<svg xmlns=\"http://www.w3.org/2000/svg\"></svg>")); err != ErrUnsupportedType {
+		t.Fatalf("Sniff svg returned %v, want ErrUnsupportedType", err)
+	}
+}
@@ -2,30 +2,115 @@ package utils
 
 import (
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func CreateJWT(userId string) interface{} {
-	claim := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+// Token purposes, a token minted for one flow is never accepted by another.
+const (
+	PurposeSession   = "session"
+	PurposeMFA       = "mfa"
+	PurposeMagicLink = "magic_link"
+	PurposeOIDCState = "oidc_state"
+	PurposeExport    = "data_export"
+)
+
+const (
+	mfaTokenTTL       = 5 * time.Minute
+	magicLinkTokenTTL = 15 * time.Minute
+	oidcStateTTL      = 10 * time.Minute
+)
+
+var validSigningMethods = []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}
+
+// CreateJWT issues the session token, sid is the id of the stored session
+// that has to exist for the token to be accepted.
+func CreateJWT(userId, sessionId string, expiresAt time.Time) interface{} {
+	token, err := SignJWT(jwt.MapClaims{
 		"user_id": userId,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(),
+		"sid":     sessionId,
+		"purpose": PurposeSession,
+		"exp":     expiresAt.Unix(),
 	})
-
-	token, err := claim.SignedString([]byte(os.Getenv("JWT_SECRET")))
 	if err != nil {
 		return nil
 	}
 	return token
 }
 
+// CreateMFAToken issues the short lived challenge handed out after a correct
+// password when the account still needs its second factor.
+func CreateMFAToken(userId string) (string, error) {
+	return SignJWT(jwt.MapClaims{
+		"user_id": userId,
+		"purpose": PurposeMFA,
+		"exp":     time.Now().Add(mfaTokenTTL).Unix(),
+	})
+}
+
+// CreateMagicLinkToken issues the emailed sign-in token. jti is stored on the
+// user so the token can only be exchanged once.
+func CreateMagicLinkToken(userId, jti string) (string, error) {
+	return SignJWT(jwt.MapClaims{
+		"user_id": userId,
+		"jti":     jti,
+		"purpose": PurposeMagicLink,
+		"exp":     time.Now().Add(magicLinkTokenTTL).Unix(),
+	})
+}
+
+// CreateOIDCStateToken packs the values an OIDC login must carry through the
+// provider round trip into a cookie value we can trust on the way back.
+func CreateOIDCStateToken(provider, state, nonce, verifier string) (string, error) {
+	return SignJWT(jwt.MapClaims{
+		"provider": provider,
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+		"purpose":  PurposeOIDCState,
+		"exp":      time.Now().Add(oidcStateTTL).Unix(),
+	})
+}
+
+// CreateExportToken signs the download link mailed for a finished data export.
+func CreateExportToken(userId, exportId string, expiresAt time.Time) (string, error) {
+	return SignJWT(jwt.MapClaims{
+		"user_id":   userId,
+		"export_id": exportId,
+		"purpose":   PurposeExport,
+		"exp":       expiresAt.Unix(),
+	})
+}
+
+// SignJWT signs claims with the current signing key and stamps its kid in the header.
+func SignJWT(claims jwt.MapClaims) (string, error) {
+	key := currentSigningKey()
+	if key == nil {
+		return "", fmt.Errorf("no signing key loaded")
+	}
+
+	claims["iat"] = time.Now().Unix()
+	claim := jwt.NewWithClaims(key.Method, claims)
+	claim.Header["kid"] = key.ID
+
+	return claim.SignedString(key.Private)
+}
+
 func VerifyJWT(token string) (jwt.MapClaims, error) {
 
 	verifiedToken, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
+		kid, _ := t.Header["kid"].(string)
+		key := lookupSigningKey(kid)
+		if key == nil {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		// the key decides the algorithm, never the token header
+		if t.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s for key %s", t.Method.Alg(), kid)
+		}
+		return key.Private.Public(), nil
+	}, jwt.WithValidMethods(validSigningMethods), jwt.WithExpirationRequired())
 
 	if err != nil {
 		return nil, err
@@ -39,3 +124,20 @@ func VerifyJWT(token string) (jwt.MapClaims, error) {
 
 	return claim, nil
 }
+
+// VerifyPurposeJWT verifies token and checks it was minted for purpose. Every
+// purpose but the OIDC state, which is issued before anyone signed in, must
+// carry a user.
+func VerifyPurposeJWT(token, purpose string) (jwt.MapClaims, error) {
+	claims, err := VerifyJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	if p, _ := claims["purpose"].(string); p != purpose {
+		return nil, fmt.Errorf("token is not valid for %s", purpose)
+	}
+	if _, ok := claims["user_id"].(string); !ok && purpose != PurposeOIDCState {
+		return nil, fmt.Errorf("token has no user")
+	}
+	return claims, nil
+}
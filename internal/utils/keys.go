@@ -0,0 +1,354 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TokenTTL is how long a session token stays valid.
+const TokenTTL = 24 * time.Hour
+
+const defaultKeyRotation = 30 * 24 * time.Hour
+
+type signingKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	Private   crypto.Signer
+	CreatedAt time.Time
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+var (
+	keysMu sync.RWMutex
+	// ordered oldest to newest, the newest key signs new tokens
+	signingKeys []*signingKey
+)
+
+// KeyRotationInterval reads JWT_KEY_ROTATION (a Go duration such as "720h").
+func KeyRotationInterval() time.Duration {
+	return DurationFromEnv("JWT_KEY_ROTATION", defaultKeyRotation)
+}
+
+// LoadSigningKeys reads every PEM key in JWT_KEYS_DIR and makes sure there is
+// a current signing key, generating one if needed. Without JWT_KEYS_DIR keys
+// only live in memory and every restart signs all users out.
+func LoadSigningKeys() error {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		log.Println("JWT_KEYS_DIR not set, signing keys will not survive a restart")
+	}
+
+	loaded, err := readKeyDir(dir)
+	if err != nil {
+		return err
+	}
+
+	keysMu.Lock()
+	signingKeys = loaded
+	keysMu.Unlock()
+
+	return RotateSigningKeys(KeyRotationInterval())
+}
+
+// RotateSigningKeys adds a new signing key once the current one is older than
+// interval and drops keys that can no longer have valid tokens outstanding.
+func RotateSigningKeys(interval time.Duration) error {
+	dir := os.Getenv("JWT_KEYS_DIR")
+
+	// pick up keys created by other instances sharing the directory
+	if dir != "" {
+		loaded, err := readKeyDir(dir)
+		if err != nil {
+			return err
+		}
+		keysMu.Lock()
+		signingKeys = loaded
+		keysMu.Unlock()
+	}
+
+	keysMu.RLock()
+	var current *signingKey
+	if len(signingKeys) > 0 {
+		current = signingKeys[len(signingKeys)-1]
+	}
+	keysMu.RUnlock()
+
+	if current == nil || time.Since(current.CreatedAt) >= interval {
+		key, err := generateSigningKey(os.Getenv("JWT_ALG"))
+		if err != nil {
+			return err
+		}
+		if dir != "" {
+			if err := writeKey(dir, key); err != nil {
+				return err
+			}
+		}
+		keysMu.Lock()
+		signingKeys = append(signingKeys, key)
+		keysMu.Unlock()
+		log.Printf("rotated jwt signing key, new kid %s", key.ID)
+	}
+
+	pruneSigningKeys(dir)
+	return nil
+}
+
+// retiredKeyTTL is how long a replaced key stays in the key set, the lifetime
+// of the longest lived token it may have signed. Mailed export links outlive
+// session tokens by default.
+func retiredKeyTTL() time.Duration {
+	if ttl := ExportLinkTTL(); ttl > TokenTTL {
+		return ttl
+	}
+	return TokenTTL
+}
+
+// pruneSigningKeys removes keys that were replaced more than retiredKeyTTL ago.
+func pruneSigningKeys(dir string) {
+	keysMu.Lock()
+	defer keysMu.Unlock()
+
+	var kept []*signingKey
+	for i, key := range signingKeys {
+		if i < len(signingKeys)-1 {
+			replacedAt := signingKeys[i+1].CreatedAt
+			if time.Since(replacedAt) > retiredKeyTTL() {
+				if dir != "" {
+					os.Remove(filepath.Join(dir, key.ID+".pem"))
+				}
+				continue
+			}
+		}
+		kept = append(kept, key)
+	}
+	signingKeys = kept
+}
+
+func currentSigningKey() *signingKey {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+	if len(signingKeys) == 0 {
+		return nil
+	}
+	return signingKeys[len(signingKeys)-1]
+}
+
+func lookupSigningKey(kid string) *signingKey {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+	for _, key := range signingKeys {
+		if key.ID == kid {
+			return key
+		}
+	}
+	return nil
+}
+
+// PublicJWKS returns the public half of every key that may still have valid
+// tokens outstanding, for other services to verify our tokens.
+func PublicJWKS() JWKSet {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range signingKeys {
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+		switch pub := key.Private.Public().(type) {
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// PublicKey decodes a JWK published by someone else, such as an OIDC provider.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("bad Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func generateSigningKey(alg string) (*signingKey, error) {
+	createdAt := time.Now()
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	kid := fmt.Sprintf("%d-%s", createdAt.Unix(), hex.EncodeToString(suffix))
+
+	switch strings.ToUpper(alg) {
+	case "RS256":
+		private, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		return &signingKey{ID: kid, Method: jwt.SigningMethodRS256, Private: private, CreatedAt: createdAt}, nil
+	case "", "EDDSA":
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return &signingKey{ID: kid, Method: jwt.SigningMethodEdDSA, Private: private, CreatedAt: createdAt}, nil
+	default:
+		return nil, fmt.Errorf("unsupported JWT_ALG %q, use RS256 or EdDSA", alg)
+	}
+}
+
+func readKeyDir(dir string) ([]*signingKey, error) {
+	if dir == "" {
+		keysMu.RLock()
+		defer keysMu.RUnlock()
+		return append([]*signingKey(nil), signingKeys...), nil
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	var loaded []*signingKey
+	for _, file := range files {
+		key, err := readKey(file)
+		if err != nil {
+			return nil, fmt.Errorf("loading %s: %w", file, err)
+		}
+		loaded = append(loaded, key)
+	}
+	sort.Slice(loaded, func(i, j int) bool {
+		return loaded[i].CreatedAt.Before(loaded[j].CreatedAt)
+	})
+	return loaded, nil
+}
+
+func readKey(file string) (*signingKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+	created, err := strconv.ParseInt(strings.SplitN(kid, "-", 2)[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("key id %q has no creation time", kid)
+	}
+
+	key := &signingKey{ID: kid, CreatedAt: time.Unix(created, 0)}
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method = jwt.SigningMethodRS256
+		key.Private = private
+	case ed25519.PrivateKey:
+		key.Method = jwt.SigningMethodEdDSA
+		key.Private = private
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+	return key, nil
+}
+
+func writeKey(dir string, key *signingKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	if err != nil {
+		return err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	return os.WriteFile(filepath.Join(dir, key.ID+".pem"), data, 0o600)
+}
@@ -0,0 +1,118 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"silent-notes/internal/utils/oidc/oidctest"
+	"strings"
+	"testing"
+)
+
+func newTestProvider(t *testing.T) (*Provider, *oidctest.Issuer) {
+	t.Helper()
+	issuer := oidctest.NewIssuer("client-1")
+	t.Cleanup(issuer.Close)
+	issuer.Identity = oidctest.Identity{Subject: "sub-1", Email: "ann@example.com", EmailVerified: true, PreferredUsername: "ann"}
+
+	return &Provider{
+		Name:        "mock",
+		Issuer:      issuer.URL,
+		ClientID:    "client-1",
+		RedirectURL: "http://api.test/api/v1/oauth/mock/callback",
+		Scopes:      []string{"openid", "email"},
+	}, issuer
+}
+
+// authorize follows the authorization URL and returns the code and state the
+// provider redirected back with.
+func authorize(t *testing.T, p *Provider, state, nonce, verifier string) (string, string) {
+	t.Helper()
+	authURL, err := p.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("GET authorization endpoint: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorization endpoint returned %d", resp.StatusCode)
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("parsing redirect: %v", err)
+	}
+	if !strings.HasPrefix(location.String(), p.RedirectURL) {
+		t.Fatalf("redirected to %s, want %s", location, p.RedirectURL)
+	}
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func TestExchange(t *testing.T) {
+	p, _ := newTestProvider(t)
+
+	code, state := authorize(t, p, "state-1", "nonce-1", "verifier-1")
+	if state != "state-1" {
+		t.Fatalf("state = %q, want state-1", state)
+	}
+
+	identity, err := p.Exchange(context.Background(), code, "verifier-1", "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if identity.Subject != "sub-1" || identity.Email != "ann@example.com" || !identity.EmailVerified || identity.PreferredUsername != "ann" {
+		t.Fatalf("identity = %+v", identity)
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	p, _ := newTestProvider(t)
+
+	code, _ := authorize(t, p, "state-1", "nonce-1", "verifier-1")
+	if _, err := p.Exchange(context.Background(), code, "another-verifier", "nonce-1"); err == nil {
+		t.Fatal("Exchange accepted a code with the wrong PKCE verifier")
+	}
+}
+
+func TestExchangeRejectsNonceMismatch(t *testing.T) {
+	p, _ := newTestProvider(t)
+
+	code, _ := authorize(t, p, "state-1", "nonce-1", "verifier-1")
+	if _, err := p.Exchange(context.Background(), code, "verifier-1", "nonce-2"); err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Fatalf("Exchange with another nonce returned %v, want a nonce mismatch", err)
+	}
+}
+
+func TestVerifyIDTokenWithoutKid(t *testing.T) {
+	p, issuer := newTestProvider(t)
+	issuer.OmitKid = true
+
+	// the second token arrives within keyRefreshInterval of the first fetch
+	for i := 0; i < 2; i++ {
+		token, err := issuer.IDToken(issuer.Identity, "nonce-1")
+		if err != nil {
+			t.Fatalf("IDToken: %v", err)
+		}
+		if _, err := p.verifyIDToken(context.Background(), token, "nonce-1"); err != nil {
+			t.Fatalf("token %d without kid: %v", i+1, err)
+		}
+	}
+}
+
+func TestVerifyIDTokenRejectsOtherAudience(t *testing.T) {
+	p, issuer := newTestProvider(t)
+	issuer.ClientID = "someone-else"
+
+	token, err := issuer.IDToken(issuer.Identity, "nonce-1")
+	if err != nil {
+		t.Fatalf("IDToken: %v", err)
+	}
+	if _, err := p.verifyIDToken(context.Background(), token, "nonce-1"); err == nil {
+		t.Fatal("accepted an ID token issued to another client")
+	}
+}
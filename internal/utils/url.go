@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// AppURL is the public address of the frontend, used for links in emails.
+func AppURL() string {
+	if url := os.Getenv("APP_URL"); url != "" {
+		return strings.TrimRight(url, "/")
+	}
+	return "http://localhost:5173"
+}
+
+// APIURL is the public address of this server.
+func APIURL() string {
+	if url := os.Getenv("API_URL"); url != "" {
+		return strings.TrimRight(url, "/")
+	}
+	return "http://localhost:3000"
+}
+
+// ProfileURL is the frontend page where people send username messages.
+func ProfileURL(username string) string {
+	return AppURL() + "/u/" + url.PathEscape(username)
+}
+
+// ShareURL links to the user's page, or one of their prompts, tagged with
+// utm_source and utm_medium when given so messages can be attributed.
+func ShareURL(username, prompt, source, medium string) string {
+	link := ProfileURL(username)
+	if prompt != "" {
+		link += "/" + url.PathEscape(prompt)
+	}
+	query := url.Values{}
+	if source != "" {
+		query.Set("utm_source", source)
+	}
+	if medium != "" {
+		query.Set("utm_medium", medium)
+	}
+	if len(query) > 0 {
+		link += "?" + query.Encode()
+	}
+	return link
+}
+
+// RandomToken returns n random bytes encoded for use in URLs.
+func RandomToken(n int) (string, error) {
+	raw := make([]byte, n)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
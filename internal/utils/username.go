@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"strings"
+	"time"
+)
+
+const defaultUsernameGrace = 30 * 24 * time.Hour
+
+// names that would clash with routes or could be used to impersonate staff
+var reservedUsernames = map[string]bool{
+	"admin": true, "administrator": true, "root": true, "system": true,
+	"support": true, "help": true, "staff": true, "moderator": true, "mod": true,
+	"security": true, "abuse": true, "api": true, "www": true, "mail": true,
+	"silentnotes": true, "ama": true, "official": true,
+	"dashboard": true, "settings": true, "account": true, "profile": true,
+	"signin": true, "sign-in": true, "signup": true, "sign-up": true, "login": true,
+	"logout": true, "verify": true, "u": true, "user": true, "users": true,
+	"about": true, "privacy": true, "terms": true, "null": true, "undefined": true,
+}
+
+func IsReservedUsername(username string) bool {
+	return reservedUsernames[strings.ToLower(username)]
+}
+
+// UsernameGracePeriod is how long an old username keeps resolving after a
+// rename, read from USERNAME_GRACE_PERIOD (a Go duration).
+func UsernameGracePeriod() time.Duration {
+	return DurationFromEnv("USERNAME_GRACE_PERIOD", defaultUsernameGrace)
+}